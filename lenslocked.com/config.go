@@ -3,9 +3,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/username/project-name/cookies"
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/oidc"
+	"github.com/username/project-name/password"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"os"
+	"time"
 )
 
 type PostgresConfig struct {
@@ -40,17 +46,51 @@ func DefaultPostgresConfig() PostgresConfig {
 }
 
 type Config struct {
-	Port     int            `json:"port"`
-	Env      string         `json:"env"`
-	HMACKey  string         `json:"hmac_key"`
-	Database PostgresConfig `json:"database"`
-	Mailgun  MailgunConfig  `json:"mailgun"`
+	Port    int    `json:"port"`
+	Env     string `json:"env"`
+	HMACKey string `json:"hmac_key"`
+	/*HMACKeys replaces HMACKey once keys are rotated. The first key is active, the others are
+	retired and only used to find tokens issued before the rotation. To rotate, put a new key
+	first and keep the old ones until their tokens have expired or were hashed again*/
+	HMACKeys     []hash.Key                `json:"hmac_keys"`
+	Database     PostgresConfig            `json:"database"`
+	Mailgun      MailgunConfig             `json:"mailgun"`
+	Verification models.VerificationPolicy `json:"verification"`
+	OIDC         []oidc.Config             `json:"oidc"`
+	/*LoginAttempts is where failed sign ins are counted, "postgres" or "memory"*/
+	LoginAttempts string `json:"login_attempts"`
+	/*PasswordHashing is applied to new passwords, existing hashes are upgraded on sign in*/
+	PasswordHashing password.Config       `json:"password_hashing"`
+	PasswordPolicy  models.PasswordPolicy `json:"password_policy"`
+	PasswordReset   models.ResetPolicy    `json:"password_reset"`
+	/*Registration is "open", "invite-only" or "closed", open when left empty*/
+	Registration models.RegistrationMode `json:"registration"`
+	/*ReauthMinutes is how long after signing in sensitive actions work without
+	confirming the password again, 15 when left empty*/
+	ReauthMinutes int `json:"reauth_minutes"`
+	/*Cookie configures the cookie that keeps users signed in*/
+	Cookie cookies.Config `json:"cookie"`
 }
 
 func (c Config) isProd() bool {
 	return c.Env == "prod"
 }
 
+func (c Config) reauthAfter() time.Duration {
+	if c.ReauthMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(c.ReauthMinutes) * time.Minute
+}
+
+/*HMACKeyring uses HMACKeys, or HMACKey as the only key when none are listed*/
+func (c Config) HMACKeyring() (*hash.Keyring, error) {
+	if len(c.HMACKeys) == 0 {
+		return hash.NewKeyring(hash.Key{ID: "default", Secret: c.HMACKey})
+	}
+	return hash.NewKeyring(c.HMACKeys[0], c.HMACKeys[1:]...)
+}
+
 func DefaultConfig() Config {
 	return Config{
 		Port:     3000,
@@ -6,7 +6,11 @@ import (
 )
 
 const (
-	userKey privateKey = "user"
+	userKey     privateKey = "user"
+	sessionKey  privateKey = "session"
+	tokenKey    privateKey = "api_token"
+	resourceKey privateKey = "resource"
+	adminKey    privateKey = "impersonator"
 )
 
 type privateKey string
@@ -23,3 +27,71 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+/*Session returns the session the current user signed in with*/
+func Session(ctx context.Context) *models.Session {
+	if temp := ctx.Value(sessionKey); temp != nil {
+		if session, ok := temp.(*models.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+/*WithImpersonator marks the request as made by an admin signed in as the user*/
+func WithImpersonator(ctx context.Context, admin *models.User) context.Context {
+	return context.WithValue(ctx, adminKey, admin)
+}
+
+/*Impersonator returns the admin who is signed in as the current user, nil otherwise*/
+func Impersonator(ctx context.Context) *models.User {
+	if temp := ctx.Value(adminKey); temp != nil {
+		if admin, ok := temp.(*models.User); ok {
+			return admin
+		}
+	}
+	return nil
+}
+
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+/*APIToken returns the token the request was authenticated with, nil for browser sessions*/
+func APIToken(ctx context.Context) *models.APIToken {
+	if temp := ctx.Value(tokenKey); temp != nil {
+		if token, ok := temp.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+/*WithResource stores the resource the request was authorized for*/
+func WithResource(ctx context.Context, resource interface{}) context.Context {
+	return context.WithValue(ctx, resourceKey, resource)
+}
+
+/*Gallery returns the gallery the request was authorized for*/
+func Gallery(ctx context.Context) *models.Gallery {
+	if temp := ctx.Value(resourceKey); temp != nil {
+		if gallery, ok := temp.(*models.Gallery); ok {
+			return gallery
+		}
+	}
+	return nil
+}
+
+/*Organization returns the organization the request was authorized for*/
+func Organization(ctx context.Context) *models.Organization {
+	if temp := ctx.Value(resourceKey); temp != nil {
+		if org, ok := temp.(*models.Organization); ok {
+			return org
+		}
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/email"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/oidc"
+	"github.com/username/project-name/totp"
+	"github.com/username/project-name/views"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// NewAccount creates a new Account controller.
+func NewAccount(us models.UserService, ss models.SessionService, ids models.IdentityService,
+	as models.AccountService, logins models.LoginEventService, providers []*oidc.Provider,
+	emailer email.Emailer) *Account {
+	return &Account{
+		AccountView:        views.NewView("bootstrap", "account/index"),
+		TwoFactorSetupView: views.NewView("bootstrap", "account/two_factor_setup"),
+		RecoveryCodesView:  views.NewView("bootstrap", "account/recovery_codes"),
+		RevertEmailView:    views.NewView("bootstrap", "account/revert_email"),
+		LoginsView:         views.NewView("bootstrap", "account/logins"),
+		us:                 us,
+		ss:                 ss,
+		ids:                ids,
+		as:                 as,
+		logins:             logins,
+		providers:          providers,
+		emailer:            emailer,
+	}
+}
+
+/*Account holds the settings pages of a signed in user*/
+type Account struct {
+	AccountView        *views.View
+	TwoFactorSetupView *views.View
+	RecoveryCodesView  *views.View
+	RevertEmailView    *views.View
+	LoginsView         *views.View
+	us                 models.UserService
+	ss                 models.SessionService
+	ids                models.IdentityService
+	as                 models.AccountService
+	logins             models.LoginEventService
+	providers          []*oidc.Provider
+	emailer            email.Emailer
+}
+
+/*AccountPage is the data the account page is rendered with*/
+type AccountPage struct {
+	User       *models.User
+	Sessions   []models.Session
+	CurrentID  uint
+	Identities []models.Identity
+	Providers  []*oidc.Provider
+}
+
+/*GET /account*/
+func (a *Account) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	page, err := a.page(r)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = page
+	a.AccountView.Render(w, r, vd)
+}
+
+/*GET /account/logins*/
+func (a *Account) Logins(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	events, err := a.logins.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = events
+	a.LoginsView.Render(w, r, vd)
+}
+
+/*POST /account/sessions/:id/delete*/
+func (a *Account) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusNotFound)
+		return
+	}
+	session, err := a.ss.ByID(uint(id))
+	if err != nil || session.UserID != user.ID {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err := a.ss.Delete(session.ID); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The device has been signed out",
+	})
+}
+
+/*POST /account/sessions/delete-others*/
+func (a *Account) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var keepID uint
+	if current := context.Session(r.Context()); current != nil {
+		keepID = current.ID
+	}
+	if err := a.ss.DeleteByUserID(user.ID, keepID); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "All other devices have been signed out",
+	})
+}
+
+/*ChangeEmailForm is used to request a new email address. Users without a password confirm
+with a Code of the second factor*/
+type ChangeEmailForm struct {
+	Email    string `schema:"email"`
+	Password string `schema:"password"`
+	Code     string `schema:"code"`
+}
+
+/*POST /account/email
+Nothing changes until the user follows the link sent to the new address*/
+func (a *Account) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var form ChangeEmailForm
+	if err := parseForm(r, &form); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	change, err := a.us.InitiateEmailChange(user, form.Password, form.Code, form.Email)
+	if err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	if err := a.emailer.ChangeEmail(user.Name, change.NewEmail, change.ConfirmToken); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	a.emailer.EmailChangeNotice(user.Name, change.OldEmail, change.NewEmail, change.RevertToken)
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertInfo,
+		Message: "We have sent a link to " + change.NewEmail + ", please follow it to confirm the new address",
+	})
+}
+
+/*GET /account/email/confirm?token=*/
+func (a *Account) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var form VerifyForm
+	if err := parseURLParams(r, &form); err != nil {
+		views.RedirectAlert(w, r, "/", http.StatusFound, errorAlert(err))
+		return
+	}
+	if _, err := a.us.CompleteEmailChange(form.Token); err != nil {
+		alert := errorAlert(err)
+		if err == models.ErrTokenInvalid {
+			alert.Message = "The confirmation link is invalid or has expired"
+		}
+		views.RedirectAlert(w, r, "/", http.StatusFound, alert)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Your email address has been changed",
+	})
+}
+
+/*GET /account/email/revert?token=
+The link only shows a button so that mail scanners following links don't revert anything*/
+func (a *Account) RevertEmail(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form VerifyForm
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = form
+	a.RevertEmailView.Render(w, r, vd)
+}
+
+/*POST /account/email/revert
+Whoever changed the address may have been signed in to the account, so every device is signed out*/
+func (a *Account) CompleteRevertEmail(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form VerifyForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.RevertEmailView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = form
+	user, err := a.us.RevertEmailChange(form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+		a.RevertEmailView.Render(w, r, vd)
+		return
+	}
+	if err := a.ss.DeleteByUserID(user.ID, 0); err != nil {
+		vd.SetAlert(err)
+		a.RevertEmailView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+		Level: views.AlertSuccess,
+		Message: "Your email address has been restored and all devices have been signed out. " +
+			"If you didn't change it yourself, please reset your password",
+	})
+}
+
+/*GET /account/export
+The ZIP is streamed, so once it started an error can only cut the download short*/
+func (a *Account) Export(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="lenslocked-export.zip"`)
+	if err := a.as.Export(user, w); err != nil {
+		log.Println("exporting account:", err)
+	}
+}
+
+/*DeleteAccountForm confirms the deletion with the current password*/
+type DeleteAccountForm struct {
+	Password string `schema:"password"`
+}
+
+/*POST /account/delete*/
+func (a *Account) RequestDeletion(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var form DeleteAccountForm
+	if err := parseForm(r, &form); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	if err := a.as.Deletable(user); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	if err := a.us.RequestDeletion(user, form.Password); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level: views.AlertWarning,
+		Message: "Your account will be deleted on " + user.DeletesAt().Format("Jan 2, 2006") +
+			". You can cancel the deletion until then",
+	})
+}
+
+/*POST /account/delete/cancel*/
+func (a *Account) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := a.us.CancelDeletion(user); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Your account will not be deleted",
+	})
+}
+
+/*TwoFactorSetup is the data the authenticator enrolment page is rendered with*/
+type TwoFactorSetup struct {
+	Secret string
+	URI    string
+}
+
+/*TwoFactorSettingsForm is used both to confirm and to disable two-factor authentication*/
+type TwoFactorSettingsForm struct {
+	Code     string `schema:"code"`
+	Password string `schema:"password"`
+}
+
+/*POST /account/2fa/enroll*/
+func (a *Account) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	secret, err := a.us.EnrollTOTP(user)
+	if err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	a.TwoFactorSetupView.Render(w, r, twoFactorSetup(user, secret))
+}
+
+/*POST /account/2fa/confirm*/
+func (a *Account) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	vd.Yield = twoFactorSetup(user, user.TOTPSecret)
+	var form TwoFactorSettingsForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+	codes, err := a.us.ConfirmTOTP(user, form.Code)
+	if err != nil {
+		if err == models.ErrTOTPNotEnrolled || err == models.ErrTOTPAlreadyEnabled {
+			a.renderErr(w, r, err)
+			return
+		}
+		vd.SetAlert(err)
+		a.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = codes
+	vd.Alert = &views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Two-factor authentication is now enabled",
+	}
+	a.RecoveryCodesView.Render(w, r, vd)
+}
+
+/*POST /account/2fa/disable*/
+func (a *Account) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var form TwoFactorSettingsForm
+	if err := parseForm(r, &form); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	if err := a.us.DisableTOTP(user, form.Password, form.Code); err != nil {
+		a.renderErr(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Two-factor authentication has been disabled",
+	})
+}
+
+func twoFactorSetup(user *models.User, secret string) *TwoFactorSetup {
+	return &TwoFactorSetup{
+		Secret: secret,
+		URI:    totp.URI("LensLocked", user.Email, secret),
+	}
+}
+
+func (a *Account) page(r *http.Request) (*AccountPage, error) {
+	user := context.User(r.Context())
+	page := AccountPage{User: user, Providers: a.providers}
+	if current := context.Session(r.Context()); current != nil {
+		page.CurrentID = current.ID
+	}
+	sessions, err := a.ss.ByUserID(user.ID)
+	if err != nil {
+		return &page, err
+	}
+	page.Sessions = sessions
+	identities, err := a.ids.ByUserID(user.ID)
+	if err != nil {
+		return &page, err
+	}
+	page.Identities = identities
+	return &page, nil
+}
+
+func (a *Account) renderErr(w http.ResponseWriter, r *http.Request, err error) {
+	var vd views.Data
+	vd.SetAlert(err)
+	vd.Yield, _ = a.page(r)
+	a.AccountView.Render(w, r, vd)
+}
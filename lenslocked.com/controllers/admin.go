@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/cookies"
+	"github.com/username/project-name/email"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/views"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NewAdmin creates a new Admin controller.
+func NewAdmin(us models.UserService, ss models.SessionService, gs models.GalleryService,
+	is models.ImageService, as models.AdminService, audit models.AuditService,
+	impersonation *cookies.Cookie, emailer email.Emailer) *Admin {
+	return &Admin{
+		UsersView:     views.NewView("bootstrap", "admin/users"),
+		UserView:      views.NewView("bootstrap", "admin/user"),
+		us:            us,
+		ss:            ss,
+		gs:            gs,
+		is:            is,
+		as:            as,
+		audit:         audit,
+		impersonation: impersonation,
+		emailer:       emailer,
+	}
+}
+
+/*Admin is the console admins manage users and their content with. The routes are
+expected to be guarded by the admin role*/
+type Admin struct {
+	UsersView *views.View
+	UserView  *views.View
+	us        models.UserService
+	ss        models.SessionService
+	gs        models.GalleryService
+	is        models.ImageService
+	as        models.AdminService
+	audit     models.AuditService
+	/*impersonation holds the token of the session an admin is signed in as a user with*/
+	impersonation *cookies.Cookie
+	emailer       email.Emailer
+}
+
+/*AdminUsersPage is the data the user list is rendered with*/
+type AdminUsersPage struct {
+	Query string
+	Users []models.UserSummary
+}
+
+/*AdminUserPage is the data the page of a single user is rendered with*/
+type AdminUserPage struct {
+	Summary   *models.UserSummary
+	Galleries []models.Gallery
+	Events    []models.AuditEvent
+}
+
+/*AdminSearchForm is read from the query string*/
+type AdminSearchForm struct {
+	Query string `schema:"q"`
+}
+
+/*GET /admin*/
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form AdminSearchForm
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	users, err := a.as.SearchUsers(form.Query)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = AdminUsersPage{Query: form.Query, Users: users}
+	a.UsersView.Render(w, r, vd)
+}
+
+/*GET /admin/users/:id*/
+func (a *Admin) User(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	summary, err := a.as.Summarize(user)
+	if err != nil {
+		vd.SetAlert(err)
+		summary = &models.UserSummary{User: *user}
+	}
+	galleries, err := a.gs.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	events, err := a.audit.BySubjectID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = AdminUserPage{Summary: summary, Galleries: galleries, Events: events}
+	a.UserView.Render(w, r, vd)
+}
+
+/*POST /admin/users/:id/suspend*/
+func (a *Admin) Suspend(w http.ResponseWriter, r *http.Request) {
+	user, err := a.otherUserByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := a.us.Suspend(user); err != nil {
+		a.redirectUser(w, r, user, errorAlert(err))
+		return
+	}
+	if err := a.ss.DeleteByUserID(user.ID, 0); err != nil {
+		log.Println("admin: signing out suspended user:", err)
+	}
+	a.record(r, user.ID, models.AuditUserSuspend, "")
+	a.redirectUser(w, r, user, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: user.Email + " has been suspended and signed out everywhere",
+	})
+}
+
+/*POST /admin/users/:id/unsuspend*/
+func (a *Admin) Unsuspend(w http.ResponseWriter, r *http.Request) {
+	user, err := a.otherUserByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := a.us.Unsuspend(user); err != nil {
+		a.redirectUser(w, r, user, errorAlert(err))
+		return
+	}
+	a.record(r, user.ID, models.AuditUserUnsuspend, "")
+	a.redirectUser(w, r, user, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: user.Email + " can sign in again",
+	})
+}
+
+/*POST /admin/users/:id/reset-password*/
+func (a *Admin) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, err := a.otherUserByID(w, r)
+	if err != nil {
+		return
+	}
+	token, err := a.us.ForcePasswordReset(user)
+	if err != nil {
+		a.redirectUser(w, r, user, errorAlert(err))
+		return
+	}
+	a.record(r, user.ID, models.AuditUserResetPassword, "")
+	alert := views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The password of " + user.Email + " has been cleared and a reset link was sent",
+	}
+	if err := a.emailer.ResetPw(user.Email, token); err != nil {
+		alert = views.Alert{
+			Level:   views.AlertWarning,
+			Message: "The password has been cleared but we could not email the reset link",
+		}
+	}
+	a.redirectUser(w, r, user, alert)
+}
+
+/*POST /admin/galleries/:id/delete*/
+func (a *Admin) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	if err != nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	owner := &models.User{}
+	owner.ID = gallery.UserID
+	if err := a.gs.Delete(gallery.ID); err != nil {
+		a.redirectUser(w, r, owner, errorAlert(err))
+		return
+	}
+	a.is.DeleteAll(gallery.ID)
+	a.record(r, gallery.UserID, models.AuditGalleryDelete, gallery.Title)
+	a.redirectUser(w, r, owner, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The gallery " + gallery.Title + " has been deleted",
+	})
+}
+
+/*POST /admin/users/:id/impersonate*/
+func (a *Admin) Impersonate(w http.ResponseWriter, r *http.Request) {
+	user, err := a.otherUserByID(w, r)
+	if err != nil {
+		return
+	}
+	if user.HasRole(models.RoleAdmin) {
+		a.redirectUser(w, r, user, errorAlert(models.ErrImpersonateAdmin))
+		return
+	}
+	if user.Suspended() {
+		a.redirectUser(w, r, user, errorAlert(models.ErrImpersonateSuspended))
+		return
+	}
+	admin := context.User(r.Context())
+	session := models.Session{
+		UserID:         user.ID,
+		ImpersonatorID: admin.ID,
+		UserAgent:      r.UserAgent(),
+		IP:             clientIP(r),
+		ExpiresAt:      time.Now().Add(models.ImpersonationLifetime),
+	}
+	/*without an audit record nobody gets to sign in as the user*/
+	if err := a.audit.Record(admin.ID, user.ID, models.AuditImpersonationStart, "", clientIP(r)); err != nil {
+		a.redirectUser(w, r, user, errorAlert(err))
+		return
+	}
+	if err := a.ss.Create(&session); err != nil {
+		a.redirectUser(w, r, user, errorAlert(err))
+		return
+	}
+	a.impersonation.Set(w, session.Token, session.ExpiresAt)
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+/*POST /admin/impersonation/stop*/
+func (a *Admin) StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	admin := context.Impersonator(r.Context())
+	if admin == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	user := context.User(r.Context())
+	if token, err := a.impersonation.Value(r); err == nil {
+		if session, err := a.ss.ByToken(token); err == nil {
+			a.ss.Delete(session.ID)
+		}
+	}
+	a.impersonation.Clear(w)
+	if err := a.audit.Record(admin.ID, user.ID, models.AuditImpersonationStop, "", clientIP(r)); err != nil {
+		log.Println("admin: recording the end of an impersonation:", err)
+	}
+	a.redirectUser(w, r, user, views.Alert{
+		Level:   views.AlertInfo,
+		Message: "You are no longer signed in as " + user.Email,
+	})
+}
+
+/*record adds an action of the current admin to the audit log. The action already
+happened, so a failure is only logged*/
+func (a *Admin) record(r *http.Request, subjectID uint, action, detail string) {
+	admin := context.User(r.Context())
+	if err := a.audit.Record(admin.ID, subjectID, action, detail, clientIP(r)); err != nil {
+		log.Printf("admin: recording %s: %v", action, err)
+	}
+}
+
+/*userByID looks up the user of the URL, it answers with a 404 itself when there is none*/
+func (a *Admin) userByID(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusNotFound)
+		return nil, err
+	}
+	user, err := a.us.ByID(uint(id))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, err
+	}
+	return user, nil
+}
+
+/*otherUserByID is userByID for actions admins may not take against themselves*/
+func (a *Admin) otherUserByID(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	user, err := a.userByID(w, r)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == context.User(r.Context()).ID {
+		a.redirectUser(w, r, user, errorAlert(models.ErrAdminSelf))
+		return nil, models.ErrAdminSelf
+	}
+	return user, nil
+}
+
+func (a *Admin) redirectUser(w http.ResponseWriter, r *http.Request, user *models.User, alert views.Alert) {
+	views.RedirectAlert(w, r, "/admin/users/"+strconv.Itoa(int(user.ID)), http.StatusFound, alert)
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/views"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewAPITokens creates a new APITokens controller.
+func NewAPITokens(ats models.APITokenService) *APITokens {
+	return &APITokens{
+		IndexView: views.NewView("bootstrap", "account/api_tokens"),
+		ats:       ats,
+	}
+}
+
+/*APITokens lets users manage the tokens their scripts sign in with*/
+type APITokens struct {
+	IndexView *views.View
+	ats       models.APITokenService
+}
+
+/*APITokensPage is the data the token settings are rendered with. NewToken is only
+set right after a token was created, it is never shown again*/
+type APITokensPage struct {
+	Tokens   []models.APIToken
+	Scopes   []string
+	NewToken string
+}
+
+/*APITokenForm is used to create a token. ExpiresIn is a number of days, 0 never expires*/
+type APITokenForm struct {
+	Name      string   `schema:"name"`
+	Scopes    []string `schema:"scopes"`
+	ExpiresIn int      `schema:"expires_in"`
+}
+
+/*GET /account/tokens*/
+func (at *APITokens) Index(w http.ResponseWriter, r *http.Request) {
+	at.render(w, r, nil, "")
+}
+
+/*POST /account/tokens*/
+func (at *APITokens) Create(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var form APITokenForm
+	if err := parseForm(r, &form); err != nil {
+		at.render(w, r, err, "")
+		return
+	}
+	token := models.APIToken{
+		UserID: user.ID,
+		Name:   form.Name,
+		Scopes: strings.Join(form.Scopes, " "),
+	}
+	if form.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := at.ats.Create(&token); err != nil {
+		at.render(w, r, err, "")
+		return
+	}
+	at.render(w, r, nil, token.Token)
+}
+
+/*POST /account/tokens/:id/delete*/
+func (at *APITokens) Delete(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusNotFound)
+		return
+	}
+	if err := at.ats.Revoke(user, uint(id)); err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		at.render(w, r, err, "")
+		return
+	}
+	views.RedirectAlert(w, r, "/account/tokens", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The token has been revoked",
+	})
+}
+
+func (at *APITokens) render(w http.ResponseWriter, r *http.Request, err error, newToken string) {
+	var vd views.Data
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	page := APITokensPage{Scopes: models.APIScopes, NewToken: newToken}
+	tokens, err := at.ats.ByUserID(context.User(r.Context()).ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	page.Tokens = tokens
+	vd.Yield = page
+	at.IndexView.Render(w, r, vd)
+}
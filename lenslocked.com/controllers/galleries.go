@@ -7,37 +7,61 @@ import (
 	"github.com/username/project-name/models"
 	"github.com/username/project-name/views"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 const (
 	maxMultipartMem = 1 << 20 // 1 megabyte
 )
 
-func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
+func NewGalleries(gs models.GalleryService, is models.ImageService, orgs models.OrganizationService,
+	policy *models.Policy, r *mux.Router) *Galleries {
 	return &Galleries{
-		New:       views.NewView("bootstrap", "galleries/new"),
+		NewView:   views.NewView("bootstrap", "galleries/new"),
 		ShowView:  views.NewView("bootstrap", "galleries/show"),
 		EditView:  views.NewView("bootstrap", "galleries/edit"),
 		IndexView: views.NewView("bootstrap", "galleries/index"),
 		gs:        gs,
 		is:        is,
+		orgs:      orgs,
+		policy:    policy,
 		r:         r,
 	}
 }
 
 type Galleries struct {
-	New       *views.View
+	NewView   *views.View
 	IndexView *views.View
 	ShowView  *views.View
 	EditView  *views.View
 	gs        models.GalleryService
 	r         *mux.Router
 	is        models.ImageService
+	orgs      models.OrganizationService
+	policy    *models.Policy
 }
 
+/*GalleryForm is used to create and update galleries. OrganizationID creates the gallery
+for an organization instead of the user, it is ignored on updates. Updates without a
+Visibility leave it alone*/
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title          string `schema:"title"`
+	OrganizationID uint   `schema:"organization_id"`
+	Visibility     string `schema:"visibility"`
+}
+
+/*GalleriesPage is the data the list of galleries is rendered with. Teams holds the
+galleries of every organization the user is a member of*/
+type GalleriesPage struct {
+	Galleries []models.Gallery
+	Teams     []TeamGalleries
+}
+
+type TeamGalleries struct {
+	Membership models.Membership
+	Galleries  []models.Gallery
 }
 
 /*GET /galleries*/
@@ -48,35 +72,117 @@ func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	memberships, err := g.orgs.MembershipsByUserID(user.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	page := GalleriesPage{Galleries: galleries}
+	for _, m := range memberships {
+		team, err := g.gs.ByOrganizationID(m.OrganizationID)
+		if err != nil {
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		page.Teams = append(page.Teams, TeamGalleries{Membership: m, Galleries: team})
+	}
 	var vd views.Data
-	vd.Yield = galleries
+	vd.Yield = page
 	g.IndexView.Render(w, r, vd)
 }
 
+/*NewGalleryPage is the data the new gallery form is rendered with. Organizations are the
+ones the user may create galleries for*/
+type NewGalleryPage struct {
+	Organizations []models.Organization
+}
+
+/*GET /galleries/new*/
+func (g *Galleries) New(w http.ResponseWriter, r *http.Request) {
+	g.renderNew(w, r, views.Data{})
+}
+
+func (g *Galleries) renderNew(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	var page NewGalleryPage
+	memberships, err := g.orgs.MembershipsByUserID(user.ID)
+	if err != nil && vd.Alert == nil {
+		vd.SetAlert(err)
+	}
+	for _, m := range memberships {
+		if g.policy.Can(user, models.PermGalleryCreate, m.Organization) {
+			page.Organizations = append(page.Organizations, *m.Organization)
+		}
+	}
+	vd.Yield = page
+	g.NewView.Render(w, r, vd)
+}
+
 //GET /galleries/:id
 func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
-	if err != nil {
-		return
-	}
+	gallery := context.Gallery(r.Context())
 	var vd views.Data
 	vd.Yield = gallery
 	g.ShowView.Render(w, r, vd)
 }
 
-//GET /galleries/:id/edit
-func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+/*GET /g/:slug
+Visitors of an unlisted gallery may not load its images by their path alone, the
+paths get a token instead*/
+func (g *Galleries) ShowShared(w http.ResponseWriter, r *http.Request) {
+	gallery := context.Gallery(r.Context())
+	var vd views.Data
+	if err := g.gs.SignImages(gallery); err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = gallery
+	g.ShowView.Render(w, r, vd)
+}
+
+/*ImageRoutes registers the images directory. Gallery images go to Image whatever the
+method, so HEAD and OPTIONS requests can't reach them through a file server. Only the
+avatars are served as plain files. scoped wraps Image with the API token scope that reading
+galleries needs*/
+func (g *Galleries) ImageRoutes(r *mux.Router, dir string, scoped func(http.HandlerFunc) http.HandlerFunc) {
+	r.HandleFunc("/images/galleries/{id:[0-9]+}/{filename}", scoped(g.Image))
+	r.PathPrefix("/images/avatars/").Handler(http.StripPrefix("/images/avatars/",
+		Files(filepath.Join(dir, "avatars"))))
+}
+
+/*GET /images/galleries/:id/:filename
+Images are served to whoever may view their gallery, or with a token from ShowShared
+while the gallery is still shared by its link*/
+func (g *Galleries) Image(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	filename := mux.Vars(r)["filename"]
+	if err != nil || filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	gallery, err := g.gs.ByID(uint(id))
 	if err != nil {
+		http.NotFound(w, r)
 		return
 	}
 	user := context.User(r.Context())
-
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	if !g.policy.Can(user, models.PermGalleryView, gallery) &&
+		!(g.gs.ImageTokenValid(gallery, r.URL.Query().Get("t")) &&
+			g.policy.Can(user, models.PermGalleryViewLink, gallery)) {
+		http.NotFound(w, r)
 		return
 	}
+	img := models.Image{GalleryID: gallery.ID, Filename: filename}
+	http.ServeFile(w, r, img.RelativePath())
+}
 
+//GET /galleries/:id/edit
+func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
+	gallery := context.Gallery(r.Context())
 	var vd views.Data
 	vd.Yield = gallery
 	g.EditView.Render(w, r, vd)
@@ -84,19 +190,7 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 
 /*POST /galleries/:id/update */
 func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
-
-	if err != nil {
-		return
-	}
-
-	user := context.User(r.Context())
-
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
-
+	gallery := context.Gallery(r.Context())
 	var vd views.Data
 	var form GalleryForm
 	vd.Yield = gallery
@@ -108,11 +202,13 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	gallery.Title = form.Title
+	if form.Visibility != "" {
+		gallery.Visibility = models.Visibility(form.Visibility)
+	}
 
-	err = g.gs.Update(gallery)
-	if err != nil {
+	if err := g.gs.Update(gallery); err != nil {
 		vd.SetAlert(err)
-		g.EditView.Render(w, r, gallery)
+		g.EditView.Render(w, r, vd)
 		return
 	}
 	vd.Alert = &views.Alert{
@@ -124,21 +220,10 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 
 //POST /galleries/:id/images
 func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
-
-	if err != nil {
-		return
-	}
-	user := context.User(r.Context())
-
-	if user.ID != gallery.UserID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
-
+	gallery := context.Gallery(r.Context())
 	var vd views.Data
 	vd.Yield = gallery
-	if err = r.ParseMultipartForm(maxMultipartMem); err != nil {
+	if err := r.ParseMultipartForm(maxMultipartMem); err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
@@ -171,15 +256,7 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 
 //POST /galleries/:id/images
 func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
-	if err != nil {
-		return
-	}
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
+	gallery := context.Gallery(r.Context())
 	filename := mux.Vars(r)["filename"]
 	i := models.Image{
 		GalleryID: gallery.ID,
@@ -204,26 +281,15 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 
 /*POST /galleries/:id/delete */
 func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
-	if err != nil {
-		return
-	}
-	user := context.User(r.Context())
-
+	gallery := context.Gallery(r.Context())
 	var vd views.Data
-
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
-	err = g.gs.Delete(gallery.ID)
-
-	if err != nil {
+	if err := g.gs.Delete(gallery.ID); err != nil {
 		vd.SetAlert(err)
 		vd.Yield = gallery
 		g.EditView.Render(w, r, vd)
 		return
 	}
+	g.is.DeleteAll(gallery.ID)
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
@@ -233,19 +299,33 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	var form GalleryForm
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		g.New.Render(w, r, vd)
+		g.renderNew(w, r, vd)
 		return
 	}
 
 	user := context.User(r.Context())
+	if !g.policy.Can(user, models.PermGalleryCreate, nil) {
+		vd.SetAlert(models.ErrEmailNotVerified)
+		g.renderNew(w, r, vd)
+		return
+	}
+	if form.OrganizationID != 0 {
+		org, err := g.orgs.ByID(form.OrganizationID)
+		if err != nil || !g.policy.Can(user, models.PermGalleryCreate, org) {
+			vd.SetAlert(models.ErrOrgPermission)
+			g.renderNew(w, r, vd)
+			return
+		}
+	}
 
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:          form.Title,
+		UserID:         user.ID,
+		OrganizationID: form.OrganizationID,
 	}
 	if err := g.gs.Create(&gallery); err != nil {
 		vd.SetAlert(err)
-		g.New.Render(w, r, vd)
+		g.renderNew(w, r, vd)
 		return
 	}
 	url, err := g.r.Get("edit_gallery").URL("id", fmt.Sprintf("%v", gallery.ID))
@@ -256,21 +336,26 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url.String(), http.StatusFound)
 }
 
-func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+/*LoadGalleryBySlug is the middleware.Loader for shared links, with the images of the gallery*/
+func (g *Galleries) LoadGalleryBySlug(r *http.Request) (interface{}, error) {
+	gallery, err := g.gs.BySlug(mux.Vars(r)["slug"])
 	if err != nil {
-		http.Error(w, "Invalid ID gallery", http.StatusNotFound)
 		return nil, err
 	}
+	images, _ := g.is.ByGalleryID(gallery.ID)
+	gallery.Images = images
+	return gallery, nil
+}
+
+/*LoadGallery is the middleware.Loader for routes with a gallery {id}. The images of
+the gallery are loaded as well*/
+func (g *Galleries) LoadGallery(r *http.Request) (interface{}, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, models.ErrNotFound
+	}
 	gallery, err := g.gs.ByID(uint(id))
 	if err != nil {
-		switch err {
-		case models.ErrNotFound:
-			http.Error(w, "Gallery not found", http.StatusNotFound)
-		default:
-			http.Error(w, "Oops, something went wrong", http.StatusInternalServerError)
-		}
 		return nil, err
 	}
 	images, _ := g.is.ByGalleryID(gallery.ID)
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/middleware"
+	"github.com/username/project-name/models"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	privateGalleryID = 1
+	publicGalleryID  = 2
+	galleryOwnerID   = 7
+)
+
+type fakeGalleries struct {
+	models.GalleryService
+}
+
+func (fg *fakeGalleries) ByID(id uint) (*models.Gallery, error) {
+	gallery := models.Gallery{Model: gorm.Model{ID: id}, UserID: galleryOwnerID}
+	switch id {
+	case privateGalleryID:
+		gallery.Visibility = models.VisibilityPrivate
+	case publicGalleryID:
+		gallery.Visibility = models.VisibilityPublic
+	default:
+		return nil, models.ErrNotFound
+	}
+	return &gallery, nil
+}
+
+func (fg *fakeGalleries) ImageTokenValid(gallery *models.Gallery, token string) bool {
+	return false
+}
+
+/*fakeOwners only knows the owner of the galleries*/
+type fakeOwners struct {
+	models.UserDB
+}
+
+func (fo *fakeOwners) ByID(id uint) (*models.User, error) {
+	if id != galleryOwnerID {
+		return nil, models.ErrNotFound
+	}
+	return &models.User{}, nil
+}
+
+/*testingImages serves an images directory with one file per gallery and an avatar*/
+func testingImages(t *testing.T) *mux.Router {
+	dir := t.TempDir()
+	for _, path := range []string{"galleries/1/secret.jpg", "galleries/2/public.jpg", "avatars/7/me.jpg"} {
+		path = filepath.Join(dir, "images", path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("image "+filepath.Base(path)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	/*gallery images are served relative to the working directory*/
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	r := mux.NewRouter()
+	policy := models.NewPolicy(&fakeOwners{}, nil, models.VerificationPolicy{})
+	g := &Galleries{gs: &fakeGalleries{}, policy: policy, r: r}
+	mw := &middleware.RequireUser{}
+	g.ImageRoutes(r, "images", func(next http.HandlerFunc) http.HandlerFunc {
+		return mw.ScopeFn(models.ScopeGalleriesRead, next)
+	})
+	return r
+}
+
+func TestImagesOfPrivateGalleriesAreNotServed(t *testing.T) {
+	r := testingImages(t)
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPost, http.MethodPut}
+	for _, method := range methods {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/images/galleries/1/secret.jpg", nil))
+		if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "secret.jpg") {
+			t.Errorf("%s: expected the private image to be withheld, got %d %q", method, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestImageDirectoriesAreNotListed(t *testing.T) {
+	r := testingImages(t)
+	for _, path := range []string{"/images/", "/images/galleries/", "/images/galleries/1/", "/images/avatars/",
+		"/images/avatars/7/"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPublicImagesAreServed(t *testing.T) {
+	r := testingImages(t)
+	for _, path := range []string{"/images/galleries/2/public.jpg", "/images/avatars/7/me.jpg"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), filepath.Base(path)) {
+			t.Errorf("%s: expected the image, got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+/*TestImagesNeedTheReadScope signs the owner of the galleries in with an API token, which
+only gets to the images of the owner with the galleries:read scope*/
+func TestImagesNeedTheReadScope(t *testing.T) {
+	r := testingImages(t)
+	owner := &models.User{Model: gorm.Model{ID: galleryOwnerID}}
+	tests := []struct {
+		scopes string
+		want   int
+	}{
+		{models.ScopeImagesWrite, http.StatusForbidden},
+		{models.ScopeGalleriesWrite, http.StatusForbidden},
+		{models.ScopeGalleriesRead, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/images/galleries/1/secret.jpg", nil)
+		ctx := context.WithUser(req.Context(), owner)
+		ctx = context.WithAPIToken(ctx, &models.APIToken{UserID: galleryOwnerID, Scopes: tt.scopes})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req.WithContext(ctx))
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d %q", tt.scopes, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
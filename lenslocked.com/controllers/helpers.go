@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"github.com/gorilla/schema"
+	"github.com/username/project-name/views"
+	"net"
 	"net/http"
 	"net/url"
 )
@@ -29,3 +31,19 @@ func parseValues(values url.Values, dst interface{}) error {
 	}
 	return nil
 }
+
+/*clientIP returns the address of the client without the port*/
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*errorAlert turns err into an alert that can be persisted with views.RedirectAlert*/
+func errorAlert(err error) views.Alert {
+	var vd views.Data
+	vd.SetAlert(err)
+	return *vd.Alert
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/email"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/views"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*defaultInvitationDays is used when the form doesn't say how long an invitation lasts*/
+const defaultInvitationDays = 7
+
+// NewInvitations creates a new Invitations controller.
+func NewInvitations(is models.InvitationService, policy *models.Policy, emailer email.Emailer) *Invitations {
+	return &Invitations{
+		IndexView: views.NewView("bootstrap", "account/invitations"),
+		is:        is,
+		policy:    policy,
+		emailer:   emailer,
+	}
+}
+
+/*Invitations lets users invite others while sign ups are invite-only*/
+type Invitations struct {
+	IndexView *views.View
+	is        models.InvitationService
+	policy    *models.Policy
+	emailer   email.Emailer
+}
+
+/*InvitationsPage is the data the invitations page is rendered with. NewLink is only
+set right after an invitation was issued, the code is never shown again*/
+type InvitationsPage struct {
+	Invitations []models.Invitation
+	Mode        models.RegistrationMode
+	CanCreate   bool
+	CanMultiUse bool
+	NewLink     string
+}
+
+/*InvitationForm is used to issue an invitation. Email is optional, ExpiresIn is a number of days*/
+type InvitationForm struct {
+	Email     string `schema:"email"`
+	MaxUses   int    `schema:"max_uses"`
+	ExpiresIn int    `schema:"expires_in"`
+}
+
+/*GET /account/invitations*/
+func (i *Invitations) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	i.render(w, r, vd, "")
+}
+
+/*POST /account/invitations*/
+func (i *Invitations) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	var form InvitationForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		i.render(w, r, vd, "")
+		return
+	}
+	if !i.policy.Can(user, models.PermInvitationCreate, nil) {
+		vd.SetAlert(models.ErrEmailNotVerified)
+		i.render(w, r, vd, "")
+		return
+	}
+	if form.MaxUses <= 0 || !i.policy.Can(user, models.PermInvitationMultiUse, nil) {
+		form.MaxUses = 1
+	}
+	if form.ExpiresIn <= 0 {
+		form.ExpiresIn = defaultInvitationDays
+	}
+	inv := models.Invitation{
+		InviterID: user.ID,
+		Email:     form.Email,
+		MaxUses:   form.MaxUses,
+		ExpiresAt: time.Now().AddDate(0, 0, form.ExpiresIn),
+	}
+	if err := i.is.Create(&inv); err != nil {
+		vd.SetAlert(err)
+		i.render(w, r, vd, "")
+		return
+	}
+
+	vd.Alert = &views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The invitation has been created",
+	}
+	if inv.Email != "" {
+		vd.Alert.Message = "The invitation has been sent to " + inv.Email
+		if err := i.emailer.Invite(user.Name, inv.Email, inv.Code, inv.ExpiresAt); err != nil {
+			vd.Alert = &views.Alert{
+				Level:   views.AlertWarning,
+				Message: "The invitation has been created but we could not email it, please share the link instead",
+			}
+		}
+	}
+	v := url.Values{}
+	v.Set("invite", inv.Code)
+	i.render(w, r, vd, "/signup?"+v.Encode())
+}
+
+/*POST /account/invitations/:id/delete*/
+func (i *Invitations) Delete(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusNotFound)
+		return
+	}
+	if err := i.is.Revoke(user, uint(id)); err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		var vd views.Data
+		vd.SetAlert(err)
+		i.render(w, r, vd, "")
+		return
+	}
+	views.RedirectAlert(w, r, "/account/invitations", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The invitation has been revoked",
+	})
+}
+
+func (i *Invitations) render(w http.ResponseWriter, r *http.Request, vd views.Data, newLink string) {
+	user := context.User(r.Context())
+	page := InvitationsPage{
+		Mode:        i.is.Mode(),
+		CanCreate:   i.policy.Can(user, models.PermInvitationCreate, nil),
+		CanMultiUse: i.policy.Can(user, models.PermInvitationMultiUse, nil),
+		NewLink:     newLink,
+	}
+	invitations, err := i.is.ByInviterID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	page.Invitations = invitations
+	vd.Yield = page
+	i.IndexView.Render(w, r, vd)
+}
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/oidc"
+	"github.com/username/project-name/rand"
+	"github.com/username/project-name/views"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*oauthState is kept in a short-lived cookie between leaving for the provider and coming back.
+UserID is the signed in user who started the flow, the callback only acts for that user*/
+type oauthState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	UserID   uint   `json:"user_id,omitempty"`
+	/*Confirm flows only prove who the user is and go back to Next*/
+	Confirm bool   `json:"confirm,omitempty"`
+	Next    string `json:"next,omitempty"`
+}
+
+/*GET /oauth/:provider/signin
+Signed in users link the external account to their account instead*/
+func (u *Users) OAuthSignIn(w http.ResponseWriter, r *http.Request) {
+	p := u.provider(mux.Vars(r)["provider"])
+	if p == nil {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+	st, err := newOAuthState(p.Name)
+	if err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	if user := context.User(r.Context()); user != nil {
+		st.UserID = user.ID
+	}
+	u.startOAuth(w, r, p, st)
+}
+
+/*GET /oauth/:provider/confirm
+Users who signed in with the provider confirm who they are by signing in there again*/
+func (u *Users) OAuthConfirm(w http.ResponseWriter, r *http.Request) {
+	p := u.provider(mux.Vars(r)["provider"])
+	if p == nil {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+	var form ConfirmForm
+	parseURLParams(r, &form)
+	st, err := newOAuthState(p.Name)
+	if err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	st.UserID = context.User(r.Context()).ID
+	st.Confirm = true
+	st.Next = localPath(form.Next)
+	u.startOAuth(w, r, p, st)
+}
+
+/*startOAuth sends the user to the provider, which has to ask for the credentials again
+when the flow is to confirm who the user is*/
+func (u *Users) startOAuth(w http.ResponseWriter, r *http.Request, p *oidc.Provider, st *oauthState) {
+	authCodeURL := p.AuthCodeURL
+	if st.Confirm {
+		authCodeURL = p.ReauthCodeURL
+	}
+	authURL, err := authCodeURL(r.Context(), st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	if err := u.setOAuthState(w, st); err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+/*GET /oauth/:provider/callback*/
+func (u *Users) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	p := u.provider(mux.Vars(r)["provider"])
+	if p == nil {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+	st, err := u.getOAuthState(r)
+	u.oauthState.Clear(w)
+	q := r.URL.Query()
+	if err != nil || st.Provider != p.Name ||
+		subtle.ConstantTimeCompare([]byte(st.State), []byte(q.Get("state"))) != 1 {
+		u.oauthFailed(w, r, nil)
+		return
+	}
+	if q.Get("error") != "" {
+		u.oauthFailed(w, r, nil)
+		return
+	}
+	/*the flow has to end with the user it was started by, linking and confirming only
+	start once the user signed in recently*/
+	user := context.User(r.Context())
+	if (user == nil && st.UserID != 0) || (user != nil && user.ID != st.UserID) {
+		u.oauthFailed(w, r, nil)
+		return
+	}
+	token, err := p.Exchange(r.Context(), q.Get("code"), st.Verifier)
+	if err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	claims, err := p.Verify(r.Context(), token.IDToken, st.Nonce)
+	if err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	ext := models.ExternalIdentity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		AuthTime:      claims.AuthenticatedAt(),
+	}
+
+	if st.Confirm {
+		if err := u.ids.Reauthenticate(user, ext); err != nil {
+			v := url.Values{}
+			v.Set("next", st.Next)
+			views.RedirectAlert(w, r, "/confirm?"+v.Encode(), http.StatusFound, errorAlert(err))
+			return
+		}
+		if err := u.confirmed(w, r, st.Next); err != nil {
+			views.RedirectAlert(w, r, "/account", http.StatusFound, errorAlert(err))
+		}
+		return
+	}
+	if user != nil {
+		alert := views.Alert{
+			Level:   views.AlertSuccess,
+			Message: "Your " + p.DisplayName + " account has been connected",
+		}
+		if err := u.ids.Link(user, ext); err != nil {
+			alert = errorAlert(err)
+		}
+		views.RedirectAlert(w, r, "/account", http.StatusFound, alert)
+		return
+	}
+
+	user, err = u.ids.SignIn(ext)
+	if err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		if err := u.startTwoFactor(w, r, user, false); err != nil {
+			u.oauthFailed(w, r, err)
+		}
+		return
+	}
+	if err := u.signIn(w, r, user, models.LoginOAuth, false); err != nil {
+		u.oauthFailed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+/*POST /account/identities/:id/delete*/
+func (u *Users) OAuthUnlink(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The account has been disconnected",
+	}
+	if err := u.ids.Unlink(user, uint(id)); err != nil {
+		alert = errorAlert(err)
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, alert)
+}
+
+func (u *Users) provider(name string) *oidc.Provider {
+	for _, p := range u.providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+/*oauthFailed sends the user back to the sign in page. err is only shown if it is a public error*/
+func (u *Users) oauthFailed(w http.ResponseWriter, r *http.Request, err error) {
+	alert := errorAlert(err)
+	if _, ok := err.(views.PublicError); !ok {
+		alert.Message = "We could not sign you in with that account, please try again"
+	}
+	views.RedirectAlert(w, r, "/signin", http.StatusFound, alert)
+}
+
+func newOAuthState(provider string) (*oauthState, error) {
+	state, err := rand.String(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.String(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return nil, err
+	}
+	return &oauthState{
+		Provider: provider,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+	}, nil
+}
+
+func (u *Users) setOAuthState(w http.ResponseWriter, st *oauthState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	u.oauthState.Set(w, base64.RawURLEncoding.EncodeToString(b), time.Now().Add(10*time.Minute))
+	return nil
+}
+
+func (u *Users) getOAuthState(r *http.Request) (*oauthState, error) {
+	value, err := u.oauthState.Value(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var st oauthState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/cookies"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/oidc"
+	"github.com/username/project-name/oidc/oidctest"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oidcClientID   = "lenslocked"
+	oidcSubject    = "1234567890"
+	oidcCallback   = "http://localhost:3000/oauth/mock/callback"
+	providerUserID = 3
+)
+
+/*fakeIdentities knows the identity the test issuer signs in, which belongs to providerUserID*/
+type fakeIdentities struct {
+	models.IdentityService
+}
+
+func (fi *fakeIdentities) ByUserID(userID uint) ([]models.Identity, error) {
+	if userID != providerUserID {
+		return nil, nil
+	}
+	return []models.Identity{{UserID: userID, Provider: "mock", Subject: oidcSubject}}, nil
+}
+
+func (fi *fakeIdentities) Reauthenticate(user *models.User, ext models.ExternalIdentity) error {
+	if user.ID != providerUserID || ext.Provider != "mock" || ext.Subject != oidcSubject {
+		return models.ErrIdentityNotYours
+	}
+	if time.Since(ext.AuthTime) > time.Minute {
+		return models.ErrIdentityStale
+	}
+	return nil
+}
+
+/*fakeSessions keeps the sessions that were updated*/
+type fakeSessions struct {
+	models.SessionService
+	updated []models.Session
+}
+
+func (fs *fakeSessions) Update(session *models.Session) error {
+	fs.updated = append(fs.updated, *session)
+	return nil
+}
+
+/*signedIn runs the handler for a password-less user whose session is an hour old*/
+func signedIn(handler http.HandlerFunc, r *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	user := &models.User{Model: gorm.Model{ID: providerUserID}}
+	session := &models.Session{Model: gorm.Model{ID: 9}, UserID: providerUserID,
+		AuthenticatedAt: time.Now().Add(-time.Hour)}
+	ctx := context.WithUser(r.Context(), user)
+	ctx = context.WithSession(ctx, session)
+	w := httptest.NewRecorder()
+	handler(w, mux.SetURLVars(r.WithContext(ctx), vars))
+	return w
+}
+
+/*TestConfirmWithProvider walks a user without a password or two-factor authentication
+through confirming who they are by signing in with their provider again*/
+func TestConfirmWithProvider(t *testing.T) {
+	iss := oidctest.NewIssuer(oidcClientID)
+	defer iss.Close()
+	provider := oidc.NewProvider(oidc.Config{Name: "mock", Issuer: iss.URL, ClientID: oidcClientID,
+		RedirectURL: oidcCallback})
+	cookie, _ := cookies.Config{}.Remember(false)
+	ss := &fakeSessions{}
+	u := NewUsers(&fakeUsers{}, ss, &fakeIdentities{}, &fakeInvitations{}, nil, []*oidc.Provider{provider},
+		cookie, &fakeEmailer{})
+	vars := map[string]string{"provider": "mock"}
+
+	page := signedIn(u.Confirm, httptest.NewRequest("GET", "/confirm?next=/account/tokens", nil), vars)
+	if !strings.Contains(page.Body.String(), "/oauth/mock/confirm?next=%2faccount%2ftokens") {
+		t.Fatalf("Expected the confirmation page to offer the provider, Received\n%s", page.Body)
+	}
+
+	start := signedIn(u.OAuthConfirm, httptest.NewRequest("GET", "/oauth/mock/confirm?next=/account/tokens", nil), vars)
+	authURL, err := url.Parse(start.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), iss.URL) {
+		t.Fatalf("Expected a redirect to the provider, Received %q", start.Header().Get("Location"))
+	}
+	if q := authURL.Query(); q.Get("prompt") != "login" || q.Get("max_age") != "0" {
+		t.Errorf("Expected the provider to ask for the credentials again, Received %s", authURL)
+	}
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	callback := httptest.NewRequest("GET", res.Header.Get("Location"), nil)
+	for _, c := range start.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+	done := signedIn(u.OAuthCallback, callback, vars)
+	if loc := done.Header().Get("Location"); loc != "/account/tokens" {
+		t.Fatalf("Expected to go back to the page that needed the confirmation, Received %d %q", done.Code, loc)
+	}
+	if len(ss.updated) != 1 || !ss.updated[0].AuthenticatedWithin(time.Minute) {
+		t.Errorf("Expected the session to count as recently authenticated, Received %+v", ss.updated)
+	}
+}
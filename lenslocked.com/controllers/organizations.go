@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/email"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/views"
+	"net/http"
+	"strconv"
+)
+
+// NewOrganizations creates a new Organizations controller.
+func NewOrganizations(orgs models.OrganizationService, gs models.GalleryService, policy *models.Policy,
+	emailer email.Emailer) *Organizations {
+	return &Organizations{
+		IndexView: views.NewView("bootstrap", "organizations/index"),
+		ShowView:  views.NewView("bootstrap", "organizations/show"),
+		JoinView:  views.NewView("bootstrap", "organizations/join"),
+		orgs:      orgs,
+		gs:        gs,
+		policy:    policy,
+		emailer:   emailer,
+	}
+}
+
+/*Organizations lets photographers share galleries with the rest of their studio*/
+type Organizations struct {
+	IndexView *views.View
+	ShowView  *views.View
+	JoinView  *views.View
+	orgs      models.OrganizationService
+	gs        models.GalleryService
+	policy    *models.Policy
+	emailer   email.Emailer
+}
+
+/*OrganizationForm is used to create an organization*/
+type OrganizationForm struct {
+	Name string `schema:"name"`
+}
+
+/*OrgInvitationForm is used to invite somebody to an organization*/
+type OrgInvitationForm struct {
+	Email string `schema:"email"`
+	Role  string `schema:"role"`
+}
+
+/*JoinForm is used to accept an invitation to an organization*/
+type JoinForm struct {
+	Token string `schema:"token"`
+}
+
+/*OrganizationPage is the data the page of an organization is rendered with*/
+type OrganizationPage struct {
+	Organization *models.Organization
+	Members      []models.Membership
+	Galleries    []models.Gallery
+	CanManage    bool
+	UserID       uint
+}
+
+/*GET /organizations*/
+func (o *Organizations) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	o.renderIndex(w, r, vd)
+}
+
+func (o *Organizations) renderIndex(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	memberships, err := o.orgs.MembershipsByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = memberships
+	o.IndexView.Render(w, r, vd)
+}
+
+/*POST /organizations*/
+func (o *Organizations) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form OrganizationForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		o.renderIndex(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	org := models.Organization{Name: form.Name}
+	if err := o.orgs.Create(&org, user.ID); err != nil {
+		vd.SetAlert(err)
+		o.renderIndex(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, orgPath(&org), http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The organization has been created, invite the rest of your team below",
+	})
+}
+
+/*GET /organizations/:id*/
+func (o *Organizations) Show(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	o.renderShow(w, r, vd)
+}
+
+func (o *Organizations) renderShow(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	org := context.Organization(r.Context())
+	user := context.User(r.Context())
+	page := OrganizationPage{
+		Organization: org,
+		CanManage:    o.policy.Can(user, models.PermOrgManage, org),
+		UserID:       user.ID,
+	}
+	var err error
+	if page.Members, err = o.orgs.Members(org.ID); err != nil {
+		vd.SetAlert(err)
+	}
+	if page.Galleries, err = o.gs.ByOrganizationID(org.ID); err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = page
+	o.ShowView.Render(w, r, vd)
+}
+
+/*POST /organizations/:id/invitations*/
+func (o *Organizations) Invite(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	org := context.Organization(r.Context())
+	user := context.User(r.Context())
+	var form OrgInvitationForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		o.renderShow(w, r, vd)
+		return
+	}
+	inv, err := o.orgs.Invite(org, form.Email, models.OrgRole(form.Role))
+	if err != nil {
+		vd.SetAlert(err)
+		o.renderShow(w, r, vd)
+		return
+	}
+	if err := o.emailer.OrgInvite(user.Name, org.Name, string(inv.Role), inv.Email, inv.Token,
+		inv.ExpiresAt); err != nil {
+		vd.SetAlert(err)
+		o.renderShow(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, orgPath(org), http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The invitation has been sent to " + inv.Email,
+	})
+}
+
+/*POST /organizations/:id/members/:userID/delete
+Owners remove members, everybody else can only leave*/
+func (o *Organizations) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	org := context.Organization(r.Context())
+	user := context.User(r.Context())
+	memberID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		http.Error(w, "Invalid member ID", http.StatusNotFound)
+		return
+	}
+	member := &models.Membership{OrganizationID: org.ID, UserID: uint(memberID)}
+	if !o.policy.Can(user, models.PermMemberRemove, member) {
+		http.Error(w, "You are not allowed to do this", http.StatusForbidden)
+		return
+	}
+	if err := o.orgs.Remove(org.ID, uint(memberID)); err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		views.RedirectAlert(w, r, orgPath(org), http.StatusFound, errorAlert(err))
+		return
+	}
+	if uint(memberID) == user.ID {
+		views.RedirectAlert(w, r, "/organizations", http.StatusFound, views.Alert{
+			Level:   views.AlertSuccess,
+			Message: "You have left " + org.Name,
+		})
+		return
+	}
+	views.RedirectAlert(w, r, orgPath(org), http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "The member has been removed",
+	})
+}
+
+/*GET /organizations/join?token=
+The link only shows a button so that mail scanners following links don't accept the invitation*/
+func (o *Organizations) Join(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form JoinForm
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+		o.JoinView.Render(w, r, vd)
+		return
+	}
+	inv, err := o.orgs.Invitation(form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	vd.Yield = inv
+	o.JoinView.Render(w, r, vd)
+}
+
+/*POST /organizations/join*/
+func (o *Organizations) CompleteJoin(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form JoinForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		o.JoinView.Render(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	org, err := o.orgs.Accept(user, form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+		o.JoinView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, orgPath(org), http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Welcome to " + org.Name,
+	})
+}
+
+/*LoadOrganization is the middleware.Loader for routes with an organization {id}*/
+func (o *Organizations) LoadOrganization(r *http.Request) (interface{}, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, models.ErrNotFound
+	}
+	return o.orgs.ByID(uint(id))
+}
+
+func orgPath(org *models.Organization) string {
+	return "/organizations/" + strconv.Itoa(int(org.ID))
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/views"
+	"net/http"
+)
+
+// NewProfiles creates a new Profiles controller.
+func NewProfiles(us models.UserService, gs models.GalleryService, is models.ImageService,
+	policy *models.Policy) *Profiles {
+	return &Profiles{
+		ShowView: views.NewView("bootstrap", "profiles/show"),
+		EditView: views.NewView("bootstrap", "account/profile"),
+		us:       us,
+		gs:       gs,
+		is:       is,
+		policy:   policy,
+	}
+}
+
+/*Profiles shows the public portfolio pages and lets users edit their own*/
+type Profiles struct {
+	ShowView *views.View
+	EditView *views.View
+	us       models.UserService
+	gs       models.GalleryService
+	is       models.ImageService
+	policy   *models.Policy
+}
+
+/*ProfilePage is the data a portfolio page is rendered with*/
+type ProfilePage struct {
+	User      *models.User
+	Galleries []models.Gallery
+}
+
+/*ProfileForm holds the public details of a user. Links are one per line*/
+type ProfileForm struct {
+	Username string `schema:"username"`
+	Bio      string `schema:"bio"`
+	Links    string `schema:"links"`
+}
+
+/*GET /u/:username*/
+func (p *Profiles) Show(w http.ResponseWriter, r *http.Request) {
+	user, err := p.us.ByUsername(mux.Vars(r)["username"])
+	if err != nil || user.DeletionPending() {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	galleries, err := p.gs.ByUserID(user.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	page := ProfilePage{User: user}
+	for _, g := range galleries {
+		/*the page looks the same to everyone, including the owner*/
+		if !p.policy.Can(nil, models.PermGalleryView, &g) {
+			continue
+		}
+		g.Images, _ = p.is.ByGalleryID(g.ID)
+		page.Galleries = append(page.Galleries, g)
+	}
+	var vd views.Data
+	vd.Yield = page
+	p.ShowView.Render(w, r, vd)
+}
+
+/*GET /account/profile*/
+func (p *Profiles) Edit(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = context.User(r.Context())
+	p.EditView.Render(w, r, vd)
+}
+
+/*POST /account/profile*/
+func (p *Profiles) Update(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	vd.Yield = user
+	var form ProfileForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	user.Username = form.Username
+	user.Bio = form.Bio
+	user.Links = form.Links
+	if err := p.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/account/profile", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Your profile has been updated",
+	})
+}
+
+/*POST /account/profile/avatar*/
+func (p *Profiles) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	vd.Yield = user
+	if err := r.ParseMultipartForm(maxMultipartMem); err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		vd.SetAlert(models.ErrAvatarInvalid)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	defer file.Close()
+	filename, err := p.is.CreateAvatar(user.ID, file)
+	if err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	user.AvatarFilename = filename
+	if err := p.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/account/profile", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Your avatar has been updated",
+	})
+}
+
+/*POST /account/profile/avatar/delete*/
+func (p *Profiles) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	vd.Yield = user
+	user.AvatarFilename = ""
+	if err := p.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		p.EditView.Render(w, r, vd)
+		return
+	}
+	p.is.DeleteAvatars(user.ID)
+	views.RedirectAlert(w, r, "/account/profile", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Your avatar has been removed",
+	})
+}
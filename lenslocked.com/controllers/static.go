@@ -1,6 +1,10 @@
 package controllers
 
-import "github.com/username/project-name/views"
+import (
+	"github.com/username/project-name/views"
+	"net/http"
+	"os"
+)
 
 func NewStatic() *Static {
 	return &Static{
@@ -13,3 +17,25 @@ type Static struct {
 	Home    *views.View
 	Contact *views.View
 }
+
+/*Files serves the files in dir. Directories are answered with 404 instead of a listing*/
+func Files(dir string) http.Handler {
+	return http.FileServer(noListing{http.Dir(dir)})
+}
+
+type noListing struct {
+	http.FileSystem
+}
+
+func (fs noListing) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
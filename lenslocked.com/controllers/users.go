@@ -2,53 +2,117 @@ package controllers
 
 import (
 	"github.com/username/project-name/context"
+	"github.com/username/project-name/cookies"
 	"github.com/username/project-name/email"
 	"github.com/username/project-name/models"
+	"github.com/username/project-name/oidc"
 	"github.com/username/project-name/rand"
 	"github.com/username/project-name/views"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
 // NewUsers creates a new Users controller.
-func NewUsers(us models.UserService, emailer *email.Client) *Users {
+func NewUsers(us models.UserService, ss models.SessionService, ids models.IdentityService,
+	invitations models.InvitationService, logins models.LoginEventService, providers []*oidc.Provider,
+	cookie *cookies.Remember, emailer email.Emailer) *Users {
 	return &Users{
-		NewView:      views.NewView("bootstrap", "users/new"),
-		LoginView:    views.NewView("bootstrap", "users/signin"),
-		ForgotPwView: views.NewView("bootstrap", "users/recovery"),
-		ResetPwView:  views.NewView("bootstrap", "users/reset"),
-		us:           us,
-		emailer:      emailer,
+		NewView:        views.NewView("bootstrap", "users/new"),
+		LoginView:      views.NewView("bootstrap", "users/signin"),
+		ForgotPwView:   views.NewView("bootstrap", "users/recovery"),
+		ResetPwView:    views.NewView("bootstrap", "users/reset"),
+		TwoFactorView:  views.NewView("bootstrap", "users/two_factor"),
+		SignInLinkView: views.NewView("bootstrap", "users/signin_link"),
+		ConfirmView:    views.NewView("bootstrap", "users/confirm"),
+		us:             us,
+		ss:             ss,
+		ids:            ids,
+		invitations:    invitations,
+		logins:         logins,
+		providers:      providers,
+		cookie:         cookie,
+		mfaToken:       cookie.Cookie("mfa_token", "/signin"),
+		mfaRemember:    cookie.Cookie("mfa_remember", "/signin"),
+		device:         cookie.Cookie("device_id", "/"),
+		oauthState:     cookie.Cookie("oauth_state", "/oauth"),
+		emailer:        emailer,
 	}
 }
 
 type Users struct {
-	NewView      *views.View
-	LoginView    *views.View
-	ForgotPwView *views.View
-	ResetPwView  *views.View
-	us           models.UserService
-	emailer      *email.Client
+	NewView        *views.View
+	LoginView      *views.View
+	ForgotPwView   *views.View
+	ResetPwView    *views.View
+	TwoFactorView  *views.View
+	SignInLinkView *views.View
+	ConfirmView    *views.View
+	us             models.UserService
+	ss             models.SessionService
+	ids            models.IdentityService
+	invitations    models.InvitationService
+	logins         models.LoginEventService
+	providers      []*oidc.Provider
+	cookie         *cookies.Remember
+	mfaToken       *cookies.Cookie
+	mfaRemember    *cookies.Cookie
+	device         *cookies.Cookie
+	oauthState     *cookies.Cookie
+	emailer        email.Emailer
 }
 
+/*GET /signup. Invitation links point here with the code in the invite parameter*/
 func (u *Users) New(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
 	var form SignupForm
 	parseURLParams(r, &form)
-	u.NewView.Render(w, r, form)
+	if err := u.invitations.Check(form.Invite); err != nil && err != models.ErrInvitationRequired {
+		vd.SetAlert(err)
+	}
+	vd.Yield = u.signupPage(form)
+	u.NewView.Render(w, r, vd)
 }
 
 type SignupForm struct {
 	Name     string `schema:"name"`
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+	Invite   string `schema:"invite"`
+}
+
+/*SignupPage is the data the sign up page is rendered with*/
+type SignupPage struct {
+	SignupForm
+	InviteOnly bool
+	Closed     bool
+}
+
+func (u *Users) signupPage(form SignupForm) SignupPage {
+	mode := u.invitations.Mode()
+	return SignupPage{
+		SignupForm: form,
+		InviteOnly: mode == models.RegistrationInviteOnly,
+		Closed:     mode == models.RegistrationClosed,
+	}
 }
 
 // Create processes the signup form when a user tries to create a new user account POST /signup
+// Taken addresses get the same response as new ones, their owner is emailed instead. The
+// new user isn't signed in either, the session cookie would tell the two cases apart.
 func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	var form SignupForm
-	vd.Yield = &form
 	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		vd.Yield = u.signupPage(form)
+		u.NewView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = u.signupPage(form)
+	inv, err := u.invitations.Admit(form.Invite)
+	if err != nil {
 		vd.SetAlert(err)
 		u.NewView.Render(w, r, vd)
 		return
@@ -59,86 +123,407 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		Password: form.Password,
 	}
 	if err := u.us.Create(&user); err != nil {
+		u.invitations.Release(inv)
+		if err != models.ErrEmailIsRegistered {
+			vd.SetAlert(err)
+			u.NewView.Render(w, r, vd)
+			return
+		}
+		go u.emailer.RegistrationAttempt(user.Email)
+		u.signedUp(w, r)
+		return
+	}
+
+	/*emails are sent in the background, waiting for them would make new addresses slower to answer*/
+	go func() {
+		u.emailer.Welcome(user.Name, user.Email)
+		u.sendVerification(&user)
+	}()
+	u.signedUp(w, r)
+}
+
+func (u *Users) signedUp(w http.ResponseWriter, r *http.Request) {
+	views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+		Level:   views.AlertInfo,
+		Message: "Thanks for signing up! Please check your inbox, we have emailed you the next steps",
+	})
+}
+
+/*LoginForm is used to sign in with a password. Remember keeps the user signed in after
+the browser was closed*/
+type LoginForm struct {
+	Email    string `schema:"email"`
+	Password string `schema:"password"`
+	Remember bool   `schema:"remember"`
+}
+
+/*LoginPage is the data the sign in page is rendered with*/
+type LoginPage struct {
+	Providers []*oidc.Provider
+}
+
+/*GET /signin*/
+func (u *Users) SignIn(w http.ResponseWriter, r *http.Request) {
+	u.renderLogin(w, r, views.Data{})
+}
+
+func (u *Users) renderLogin(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	vd.Yield = &LoginPage{Providers: u.providers}
+	u.LoginView.Render(w, r, vd)
+}
+
+func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
+	vd := views.Data{}
+	var form LoginForm
+	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		u.NewView.Render(w, r, vd)
+		u.renderLogin(w, r, vd)
 		return
 	}
 
-	u.emailer.Welcome(user.Name, user.Email)
+	user, err := u.us.Authenticate(form.Email, form.Password, clientIP(r))
 
-	err := u.signIn(w, &user)
 	if err != nil {
-		http.Redirect(w, r, "/signin", http.StatusFound)
+		if err == models.ErrTooManyAttempts {
+			u.sendUnlock(form.Email)
+		}
+		u.recordFailure(r, form.Email, models.LoginPassword, err)
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		if err := u.startTwoFactor(w, r, user, form.Remember); err != nil {
+			vd.SetAlert(err)
+			u.renderLogin(w, r, vd)
+		}
+		return
+	}
+	err = u.signIn(w, r, user, models.LoginPassword, form.Remember)
+	if err != nil {
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
 		return
 	}
 
-	alert := views.Alert{
-		Level:   views.AlertSuccess,
-		Message: "Welcome to LensLocked.com",
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+/*sendUnlock emails an unlock link if the account is locked. Errors are not shown, the
+response must look the same whether or not there is an account for the address*/
+func (u *Users) sendUnlock(email string) {
+	token, err := u.us.InitiateUnlock(email)
+	if err != nil {
+		return
 	}
+	go u.emailer.Unlock(email, token)
+}
 
-	views.RedirectAlert(w, r, "/galleries", http.StatusFound, alert)
+/*GET /unlock?token=*/
+func (u *Users) Unlock(w http.ResponseWriter, r *http.Request) {
+	var form VerifyForm
+	if err := parseURLParams(r, &form); err != nil {
+		views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+			Level:   views.AlertError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	if err := u.us.CompleteUnlock(form.Token); err != nil {
+		alert := views.Alert{Level: views.AlertError, Message: views.AlertMsgGeneric}
+		if err == models.ErrTokenInvalid {
+			alert.Message = "The unlock link is invalid or has expired"
+		}
+		views.RedirectAlert(w, r, "/signin", http.StatusFound, alert)
+		return
+	}
+	views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Your account has been unlocked, you can sign in again",
+	})
 }
 
-type LoginForm struct {
+/*SignInLinkForm is used to request and to follow a password-less sign-in link*/
+type SignInLinkForm struct {
 	Email    string `schema:"email"`
-	Password string `schema:"password"`
+	Token    string `schema:"token"`
+	Remember bool   `schema:"remember"`
 }
 
-func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
-	vd := views.Data{}
-	var form SignupForm
+/*POST /signin/link*/
+func (u *Users) SendSignInLink(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form SignInLinkForm
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, r, vd)
+		u.renderLogin(w, r, vd)
 		return
 	}
+	token, err := u.us.InitiateSignIn(form.Email)
+	switch err {
+	case nil:
+		go u.emailer.SignInLink(form.Email, token)
+	case models.ErrNotFound, models.ErrTooManyRequests:
+		/*we don't tell whether the address has an account or how many links it received*/
+		err = nil
+	}
+	if err != nil {
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
+		return
+	}
+	views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+		Level:   views.AlertInfo,
+		Message: "If there is an account for this address we have emailed it a sign-in link",
+	})
+}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+/*GET /signin/link
+The link only shows a button so that mail scanners following links don't use the token up*/
+func (u *Users) SignInLink(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form SignInLinkForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.SignInLinkView.Render(w, r, vd)
+}
 
+/*POST /signin/link/complete*/
+func (u *Users) CompleteSignInLink(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form SignInLinkForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.SignInLinkView.Render(w, r, vd)
+		return
+	}
+	user, err := u.us.CompleteSignIn(form.Token)
 	if err != nil {
-		switch err {
-		case models.ErrNotFound:
-			vd.SetAlert(models.ErrNotFound)
-		case models.ErrInvalidEmailOrPassword:
-			vd.SetAlert(models.ErrNotFound)
-		default:
+		if err == models.ErrTokenInvalid {
+			views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+				Level:   views.AlertError,
+				Message: "The sign-in link is invalid or has expired",
+			})
+			return
+		}
+		vd.SetAlert(err)
+		u.SignInLinkView.Render(w, r, vd)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		if err := u.startTwoFactor(w, r, user, form.Remember); err != nil {
 			vd.SetAlert(err)
+			u.SignInLinkView.Render(w, r, vd)
 		}
-		u.LoginView.Render(w, r, vd)
 		return
 	}
-	err = u.signIn(w, user)
-	if err != nil {
+	if err := u.signIn(w, r, user, models.LoginLink, form.Remember); err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, r, vd)
+		u.SignInLinkView.Render(w, r, vd)
 		return
 	}
-
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
-func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
-	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
+/*TwoFactorForm is used to process the second step of the login*/
+type TwoFactorForm struct {
+	Code string `schema:"code"`
+}
+
+/*POST /signin/2fa*/
+func (u *Users) CompleteTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	token, err := u.mfaToken.Value(r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusFound)
+		return
+	}
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
 	}
+	user, err := u.us.CompleteTwoFactor(token, form.Code)
+	if err != nil {
+		if user != nil {
+			u.recordUserFailure(r, user, models.LoginTwoFactor, err)
+		}
+		if err == models.ErrTokenInvalid {
+			u.clearTwoFactor(w)
+			views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+				Level:   views.AlertWarning,
+				Message: "Your sign-in attempt has expired, please sign in again",
+			})
+			return
+		}
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	_, err = u.mfaRemember.Value(r)
+	remember := err == nil
+	u.clearTwoFactor(w)
+	if err := u.signIn(w, r, user, models.LoginTwoFactor, remember); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
 
-	http.SetCookie(w, &cookie)
+/*ConfirmForm is used to confirm the password before a sensitive action. Next is the
+page to go back to afterwards*/
+type ConfirmForm struct {
+	Password string `schema:"password"`
+	Code     string `schema:"code"`
+	Next     string `schema:"next"`
+}
 
+/*ConfirmPage is the data the confirmation page is rendered with. Providers are those the
+user has connected, signing in with one of them again confirms as well*/
+type ConfirmPage struct {
+	User      *models.User
+	Next      string
+	Providers []*oidc.Provider
+}
+
+/*GET /confirm*/
+func (u *Users) Confirm(w http.ResponseWriter, r *http.Request) {
+	var form ConfirmForm
+	parseURLParams(r, &form)
+	var vd views.Data
+	vd.Yield = u.confirmPage(r, form.Next)
+	u.ConfirmView.Render(w, r, vd)
+}
+
+/*POST /confirm*/
+func (u *Users) CompleteConfirm(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
 	user := context.User(r.Context())
+	var form ConfirmForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		vd.Yield = u.confirmPage(r, form.Next)
+		u.ConfirmView.Render(w, r, vd)
+		return
+	}
+	if err := u.us.Reauthenticate(user, form.Password, form.Code, clientIP(r)); err != nil {
+		vd.SetAlert(err)
+		vd.Yield = u.confirmPage(r, form.Next)
+		u.ConfirmView.Render(w, r, vd)
+		return
+	}
+	if err := u.confirmed(w, r, form.Next); err != nil {
+		vd.SetAlert(err)
+		vd.Yield = u.confirmPage(r, form.Next)
+		u.ConfirmView.Render(w, r, vd)
+	}
+}
 
-	token, _ := rand.RememberToken()
+func (u *Users) confirmPage(r *http.Request, next string) ConfirmPage {
+	user := context.User(r.Context())
+	page := ConfirmPage{User: user, Next: localPath(next)}
+	identities, err := u.ids.ByUserID(user.ID)
+	if err != nil {
+		return page
+	}
+	seen := make(map[string]bool)
+	for _, identity := range identities {
+		if p := u.provider(identity.Provider); p != nil && !seen[p.Name] {
+			seen[p.Name] = true
+			page.Providers = append(page.Providers, p)
+		}
+	}
+	return page
+}
+
+/*confirmed marks the session as recently authenticated and sends the user on to next*/
+func (u *Users) confirmed(w http.ResponseWriter, r *http.Request, next string) error {
+	session := context.Session(r.Context())
+	session.AuthenticatedAt = time.Now()
+	if err := u.ss.Update(session); err != nil {
+		return err
+	}
+	views.RedirectAlert(w, r, localPath(next), http.StatusFound, views.Alert{
+		Level:   views.AlertInfo,
+		Message: "Thanks for confirming, please go ahead",
+	})
+	return nil
+}
 
-	user.Remember = token
+/*localPath only lets through paths on this site, so the confirmation page can't be used to
+send users elsewhere*/
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/account"
+	}
+	return next
+}
 
-	u.us.Update(user)
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	u.cookie.Clear(w)
+
+	if session := context.Session(r.Context()); session != nil {
+		u.ss.Delete(session.ID)
+	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+/*VerifyForm is used to process the link sent to verify an email address*/
+type VerifyForm struct {
+	Token string `schema:"token"`
+}
+
+/*GET /verify*/
+func (u *Users) Verify(w http.ResponseWriter, r *http.Request) {
+	var form VerifyForm
+	if err := parseURLParams(r, &form); err != nil {
+		views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+			Level:   views.AlertError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	if _, err := u.us.CompleteVerification(form.Token); err != nil {
+		alert := views.Alert{Level: views.AlertError, Message: views.AlertMsgGeneric}
+		if err == models.ErrTokenInvalid {
+			alert.Message = "The verification link is invalid or has expired"
+		}
+		views.RedirectAlert(w, r, "/", http.StatusFound, alert)
+		return
+	}
+	views.RedirectAlert(w, r, "/", http.StatusFound, views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "Thank you, your email address has been verified",
+	})
+}
+
+/*POST /verify/resend*/
+func (u *Users) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	alert := views.Alert{
+		Level:   views.AlertSuccess,
+		Message: "We have sent you a new verification link",
+	}
+	if err := u.sendVerification(user); err != nil {
+		alert = errorAlert(err)
+	}
+	views.RedirectAlert(w, r, "/account", http.StatusFound, alert)
+}
+
+func (u *Users) sendVerification(user *models.User) error {
+	token, err := u.us.InitiateVerification(user)
+	if err != nil {
+		return err
+	}
+	return u.emailer.Verify(user.Name, user.Email, token)
+}
+
 /*ResetPwForm is used to process data of recovery and reset password forms*/
 type ResetPwForm struct {
 	Email    string `schema:"email"`
@@ -157,13 +542,13 @@ func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	token, err := u.us.InitiateReset(form.Email)
-	if err != nil {
-		vd.SetAlert(err)
-		u.ForgotPwView.Render(w, r, vd)
-		return
+	switch err {
+	case nil:
+		go u.emailer.ResetPw(form.Email, token)
+	case models.ErrNotFound, models.ErrTooManyRequests:
+		/*unknown addresses get the same answer, otherwise the form tells who has an account*/
+		err = nil
 	}
-
-	err = u.emailer.ResetPw(form.Email, token)
 	if err != nil {
 		vd.SetAlert(err)
 		u.ForgotPwView.Render(w, r, vd)
@@ -171,8 +556,8 @@ func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
 	}
 
 	views.RedirectAlert(w, r, "/reset", http.StatusFound, views.Alert{
-		Level:   views.AlertSuccess,
-		Message: "Instruction for resetting your password has been sent to you",
+		Level:   views.AlertInfo,
+		Message: "If there is an account for this address we have emailed it instructions to reset the password",
 	})
 }
 
@@ -200,39 +585,167 @@ func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := u.us.CompleteReset(form.Token, form.Password)
+	/*whoever knew the old password is signed out everywhere, the user is signed in again below*/
+	user, err := u.us.CompleteReset(form.Token, form.Password, 0)
 	if err != nil {
+		if user != nil {
+			u.recordUserFailure(r, user, models.LoginReset, err)
+		}
 		vd.SetAlert(err)
 		u.ResetPwView.Render(w, r, vd)
 		return
 	}
-	u.signIn(w, user)
-
+	if user.TwoFactorEnabled() {
+		if err := u.startTwoFactor(w, r, user, false); err != nil {
+			vd.SetAlert(err)
+			u.ResetPwView.Render(w, r, vd)
+		}
+		return
+	}
+	/*the password is changed at this point, so the form isn't shown again*/
+	if err := u.signIn(w, r, user, models.LoginReset, false); err != nil {
+		log.Println("signing in after a password reset:", err)
+		views.RedirectAlert(w, r, "/signin", http.StatusFound, views.Alert{
+			Level:   views.AlertInfo,
+			Message: "Your password has been changed, please sign in",
+		})
+		return
+	}
 	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
 		Level:   views.AlertSuccess,
 		Message: "Your password has been reset and you have been logged in",
 	})
 }
 
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
-		if err != nil {
-			return err
-		}
-		user.Remember = token
-		err = u.us.Update(user)
+/*signIn starts a new session for the device the request came from and hands its token over in a cookie.
+Method is how the user proved who they are, it ends up in the login history. Unless the user asked
+to be remembered the cookie is gone once the browser is closed*/
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User, method string,
+	remember bool) error {
+	session := models.Session{
+		UserID:     user.ID,
+		UserAgent:  r.UserAgent(),
+		IP:         clientIP(r),
+		Persistent: remember,
+	}
+	if err := u.ss.Create(&session); err != nil {
+		return err
+	}
+
+	var expiresAt time.Time
+	if remember {
+		expiresAt = session.ExpiresAt
+	}
+	u.cookie.Set(w, session.Token, expiresAt)
+	u.recordSignIn(w, r, user, method)
+
+	return nil
+}
+
+/*recordSignIn adds the sign in to the login history and warns the user by email when it came
+from a device they never signed in with before. The user stays signed in if that fails*/
+func (u *Users) recordSignIn(w http.ResponseWriter, r *http.Request, user *models.User, method string) {
+	event := models.LoginEvent{
+		UserID:    user.ID,
+		Method:    method,
+		Success:   true,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		DeviceID:  u.deviceID(w, r),
+	}
+	newDevice, err := u.logins.Record(&event)
+	if err != nil {
+		log.Println("recording sign in:", err)
+		return
+	}
+	if newDevice {
+		go u.emailer.NewSignIn(user.Name, user.Email, event.UserAgent, event.IP, event.CreatedAt)
+	}
+}
+
+/*recordFailure adds a failed attempt to the login history of the account with the address,
+if there is one. It runs in the background so that the response doesn't take longer for
+addresses that have an account*/
+func (u *Users) recordFailure(r *http.Request, email, method string, cause error) {
+	event := failedSignIn(r, method, cause)
+	event.DeviceID, _ = u.device.Value(r)
+	go func() {
+		user, err := u.us.ByEmail(email)
 		if err != nil {
-			return err
+			return
 		}
+		event.UserID = user.ID
+		u.recordFailed(&event)
+	}()
+}
+
+/*recordUserFailure is recordFailure for the later steps of a sign in, which already know
+the account*/
+func (u *Users) recordUserFailure(r *http.Request, user *models.User, method string, cause error) {
+	event := failedSignIn(r, method, cause)
+	event.DeviceID, _ = u.device.Value(r)
+	event.UserID = user.ID
+	u.recordFailed(&event)
+}
+
+func (u *Users) recordFailed(event *models.LoginEvent) {
+	if _, err := u.logins.Record(event); err != nil {
+		log.Println("recording failed sign in:", err)
 	}
+}
 
-	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
-		HttpOnly: true,
+/*failedSignIn describes the failure the way the user was told about it*/
+func failedSignIn(r *http.Request, method string, cause error) models.LoginEvent {
+	event := models.LoginEvent{
+		Method:    method,
+		Failure:   views.AlertMsgGeneric,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if pErr, ok := cause.(views.PublicError); ok {
+		event.Failure = pErr.Public()
+	}
+	return event
+}
+
+/*deviceCookieLifetime is how long the device cookie, which tells browsers apart in the
+login history, is kept. It outlives any session*/
+const deviceCookieLifetime = 2 * 365 * 24 * time.Hour
+
+/*deviceID returns the ID of the browser the request came from. Browsers seen for the first
+time get a new one*/
+func (u *Users) deviceID(w http.ResponseWriter, r *http.Request) string {
+	if id, err := u.device.Value(r); err == nil && id != "" {
+		return id
+	}
+	id, err := rand.Nonce()
+	if err != nil {
+		return ""
 	}
-	http.SetCookie(w, &cookie)
+	u.device.Set(w, id, time.Now().Add(deviceCookieLifetime))
+	return id
+}
 
+/*
+startTwoFactor is used instead of signIn for users with two-factor authentication. The browser
+only receives a short-lived challenge cookie, the session cookie is set once the code was verified.
+Whether to remember the user is kept in a cookie of its own until then
+*/
+func (u *Users) startTwoFactor(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) error {
+	token, err := u.us.StartTwoFactor(user)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(5 * time.Minute)
+	u.mfaToken.Set(w, token, expiresAt)
+	if remember {
+		u.mfaRemember.Set(w, "1", expiresAt)
+	}
+	http.Redirect(w, r, "/signin/2fa", http.StatusFound)
 	return nil
 }
+
+func (u *Users) clearTwoFactor(w http.ResponseWriter) {
+	u.mfaToken.Clear(w)
+	u.mfaRemember.Clear(w)
+}
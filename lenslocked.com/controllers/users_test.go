@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/username/project-name/cookies"
+	"github.com/username/project-name/models"
+	"github.com/username/project-name/views"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	registeredEmail = "taken@example.com"
+	unknownEmail    = "nobody@example.com"
+)
+
+func TestMain(m *testing.M) {
+	views.TemplateDir = "../views/"
+	os.Exit(m.Run())
+}
+
+/*fakeUsers knows a single registered address*/
+type fakeUsers struct {
+	models.UserService
+}
+
+func (fu *fakeUsers) Create(user *models.User) error {
+	if user.Email == registeredEmail {
+		return models.ErrEmailIsRegistered
+	}
+	user.ID = 2
+	return nil
+}
+
+func (fu *fakeUsers) ByEmail(email string) (*models.User, error) {
+	if email != registeredEmail {
+		return nil, models.ErrNotFound
+	}
+	return &models.User{Model: gorm.Model{ID: 1}, Email: registeredEmail}, nil
+}
+
+/*Authenticate never finds the password, the service answers an unknown email the same way*/
+func (fu *fakeUsers) Authenticate(email, password, ip string) (*models.User, error) {
+	return nil, models.ErrInvalidEmailOrPassword
+}
+
+/*CompleteTwoFactor knows the account of every sign in but never the code*/
+func (fu *fakeUsers) CompleteTwoFactor(token, code string) (*models.User, error) {
+	return &models.User{Model: gorm.Model{ID: 1}, Email: registeredEmail}, models.ErrTOTPCodeInvalid
+}
+
+/*CompleteReset takes the new password with the token it sent out, any other token belongs
+to the registered address but has expired*/
+func (fu *fakeUsers) CompleteReset(token, newPw string, keepSessionID uint) (*models.User, error) {
+	user := &models.User{Model: gorm.Model{ID: 1}, Email: registeredEmail}
+	if token != "reset-token" {
+		return user, models.ErrTokenInvalid
+	}
+	return user, nil
+}
+
+func (fu *fakeUsers) InitiateVerification(user *models.User) (string, error) {
+	return "verify-token", nil
+}
+
+func (fu *fakeUsers) InitiateReset(email string) (string, error) {
+	if email != registeredEmail {
+		return "", models.ErrNotFound
+	}
+	return "reset-token", nil
+}
+
+func (fu *fakeUsers) InitiateSignIn(email string) (string, error) {
+	if email != registeredEmail {
+		return "", models.ErrNotFound
+	}
+	return "sign-in-token", nil
+}
+
+type fakeInvitations struct {
+	models.InvitationService
+}
+
+func (fi *fakeInvitations) Mode() models.RegistrationMode                 { return models.RegistrationOpen }
+func (fi *fakeInvitations) Check(code string) error                       { return nil }
+func (fi *fakeInvitations) Admit(code string) (*models.Invitation, error) { return nil, nil }
+func (fi *fakeInvitations) Release(inv *models.Invitation) error          { return nil }
+
+/*fakeLogins keeps the login history in memory*/
+type fakeLogins struct {
+	models.LoginEventService
+	mu     sync.Mutex
+	events []models.LoginEvent
+}
+
+func (fl *fakeLogins) Record(event *models.LoginEvent) (bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.events = append(fl.events, *event)
+	return false, nil
+}
+
+/*waitFor waits for a failed sign in of the user, which is recorded in the background*/
+func (fl *fakeLogins) waitFor(t *testing.T, userID uint, method string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if fl.count(userID, method) > 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected a failed %s sign in of user %d, Received %+v", method, userID, fl.events)
+}
+
+func (fl *fakeLogins) count(userID uint, method string) int {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	n := 0
+	for _, e := range fl.events {
+		if e.UserID == userID && e.Method == method && !e.Success {
+			n++
+		}
+	}
+	return n
+}
+
+/*fakeEmailer records the kind and recipient of every email*/
+type fakeEmailer struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (fe *fakeEmailer) record(kind, to string) error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.sent = append(fe.sent, kind+" "+to)
+	return nil
+}
+
+/*waitFor waits for an email sent in the background*/
+func (fe *fakeEmailer) waitFor(t *testing.T, kind, to string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		fe.mu.Lock()
+		for _, s := range fe.sent {
+			if s == kind+" "+to {
+				fe.mu.Unlock()
+				return
+			}
+		}
+		fe.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	t.Errorf("Expected a %s email to %s, Received %v", kind, to, fe.sent)
+}
+
+func (fe *fakeEmailer) Welcome(toName, toEmail string) error { return fe.record("welcome", toEmail) }
+func (fe *fakeEmailer) RegistrationAttempt(toEmail string) error {
+	return fe.record("registration-attempt", toEmail)
+}
+func (fe *fakeEmailer) ResetPw(toEmail, token string) error { return fe.record("reset", toEmail) }
+func (fe *fakeEmailer) Verify(toName, toEmail, token string) error {
+	return fe.record("verify", toEmail)
+}
+func (fe *fakeEmailer) SignInLink(toEmail, token string) error { return fe.record("sign-in", toEmail) }
+func (fe *fakeEmailer) Unlock(toEmail, token string) error     { return fe.record("unlock", toEmail) }
+func (fe *fakeEmailer) ChangeEmail(toName, toEmail, token string) error {
+	return fe.record("change-email", toEmail)
+}
+func (fe *fakeEmailer) EmailChangeNotice(toName, toEmail, newEmail, token string) error {
+	return fe.record("email-change-notice", toEmail)
+}
+func (fe *fakeEmailer) Invite(fromName, toEmail, code string, expiresAt time.Time) error {
+	return fe.record("invite", toEmail)
+}
+func (fe *fakeEmailer) NewSignIn(toName, toEmail, userAgent, ip string, at time.Time) error {
+	return fe.record("new-sign-in", toEmail)
+}
+func (fe *fakeEmailer) OrgInvite(fromName, orgName, role, toEmail, token string, expiresAt time.Time) error {
+	return fe.record("org-invite", toEmail)
+}
+
+func testingUsers() (*Users, *fakeEmailer) {
+	emailer := &fakeEmailer{}
+	cookie, _ := cookies.Config{}.Remember(false)
+	return NewUsers(&fakeUsers{}, nil, nil, &fakeInvitations{}, &fakeLogins{}, nil, cookie, emailer), emailer
+}
+
+func post(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+/*assertSameResponse compares everything a visitor gets to see*/
+func assertSameResponse(t *testing.T, registered, unknown *httptest.ResponseRecorder) {
+	t.Helper()
+	if registered.Code != unknown.Code {
+		t.Errorf("Expected the same status, Received %d and %d", registered.Code, unknown.Code)
+	}
+	if a, b := registered.Header().Get("Location"), unknown.Header().Get("Location"); a != b {
+		t.Errorf("Expected the same redirect, Received %q and %q", a, b)
+	}
+	if a, b := cookieValues(registered), cookieValues(unknown); a != b {
+		t.Errorf("Expected the same cookies, Received %q and %q", a, b)
+	}
+	if registered.Body.String() != unknown.Body.String() {
+		t.Errorf("Expected the same body, Received\n%s\nand\n%s", registered.Body, unknown.Body)
+	}
+}
+
+func cookieValues(w *httptest.ResponseRecorder) string {
+	var values []string
+	for _, c := range w.Result().Cookies() {
+		values = append(values, c.Name+"="+c.Value)
+	}
+	return strings.Join(values, "; ")
+}
+
+func TestSignupDoesNotRevealAccounts(t *testing.T) {
+	u, emailer := testingUsers()
+	form := func(email string) url.Values {
+		return url.Values{"name": {"Jo"}, "email": {email}, "password": {"tr0mb0ne-lantern"}}
+	}
+	registered := post(u.Create, form(registeredEmail))
+	unknown := post(u.Create, form(unknownEmail))
+	assertSameResponse(t, registered, unknown)
+	if registered.Code != http.StatusFound {
+		t.Errorf("Expected a redirect, Received %d", registered.Code)
+	}
+	emailer.waitFor(t, "registration-attempt", registeredEmail)
+	emailer.waitFor(t, "verify", unknownEmail)
+}
+
+func TestRecoveryDoesNotRevealAccounts(t *testing.T) {
+	u, emailer := testingUsers()
+	registered := post(u.InitiateReset, url.Values{"email": {registeredEmail}})
+	unknown := post(u.InitiateReset, url.Values{"email": {unknownEmail}})
+	assertSameResponse(t, registered, unknown)
+	emailer.waitFor(t, "reset", registeredEmail)
+}
+
+func TestSignInLinkDoesNotRevealAccounts(t *testing.T) {
+	u, emailer := testingUsers()
+	registered := post(u.SendSignInLink, url.Values{"email": {registeredEmail}})
+	unknown := post(u.SendSignInLink, url.Values{"email": {unknownEmail}})
+	assertSameResponse(t, registered, unknown)
+	emailer.waitFor(t, "sign-in", registeredEmail)
+}
+
+/*TestLoginDoesNotRevealAccounts compares a wrong password with an unknown email. Only the
+account gets the failure in its login history*/
+func TestLoginDoesNotRevealAccounts(t *testing.T) {
+	u, _ := testingUsers()
+	wrongPassword := post(u.Login, url.Values{"email": {registeredEmail}, "password": {"not-the-password"}})
+	unknown := post(u.Login, url.Values{"email": {unknownEmail}, "password": {"not-the-password"}})
+	assertSameResponse(t, wrongPassword, unknown)
+	if wrongPassword.Code != http.StatusOK || !strings.Contains(wrongPassword.Body.String(), "Invalid email or password") {
+		t.Errorf("Expected the sign in page with an error, Received %d\n%s", wrongPassword.Code, wrongPassword.Body)
+	}
+	u.logins.(*fakeLogins).waitFor(t, 1, models.LoginPassword)
+}
+
+func TestFailedSecondStepsAreRecorded(t *testing.T) {
+	u, _ := testingUsers()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"code": {"000000"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "mfa_token", Value: "mfa-token"})
+	u.CompleteTwoFactor(httptest.NewRecorder(), r)
+	u.logins.(*fakeLogins).waitFor(t, 1, models.LoginTwoFactor)
+
+	post(u.CompleteReset, url.Values{"token": {"expired-token"}, "password": {"a-new-password"}})
+	u.logins.(*fakeLogins).waitFor(t, 1, models.LoginReset)
+}
+
+/*downSessions can't start any session*/
+type downSessions struct {
+	models.SessionService
+}
+
+func (ds *downSessions) Create(session *models.Session) error {
+	return errors.New("connection refused")
+}
+
+func TestResetWithoutSession(t *testing.T) {
+	cookie, _ := cookies.Config{}.Remember(false)
+	u := NewUsers(&fakeUsers{}, &downSessions{}, nil, &fakeInvitations{}, &fakeLogins{}, nil, cookie, &fakeEmailer{})
+	w := post(u.CompleteReset, url.Values{"token": {"reset-token"}, "password": {"a-new-password"}})
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/signin" {
+		t.Errorf("Expected a redirect to sign in, Received %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if strings.Contains(cookieValues(w), "remember_token=") {
+		t.Errorf("Expected no session cookie, Received %q", cookieValues(w))
+	}
+}
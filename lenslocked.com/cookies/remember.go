@@ -0,0 +1,139 @@
+package cookies
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultName     = "remember_token"
+	defaultLifetime = 30 * 24 * time.Hour
+	/*hostPrefix makes browsers only accept the cookie over HTTPS, for path "/" and without
+	a Domain, so a subdomain can't set or overwrite it*/
+	hostPrefix = "__Host-"
+)
+
+/*Config describes the cookie that keeps users signed in*/
+type Config struct {
+	/*Name is "remember_token" when left empty*/
+	Name string `json:"name"`
+	/*Domain lets subdomains read the cookie as well, leave it empty for this host only*/
+	Domain string `json:"domain"`
+	/*LifetimeDays is how long "keep me signed in" lasts without being used, 30 when left empty*/
+	LifetimeDays int `json:"lifetime_days"`
+	/*HostPrefix adds the __Host- prefix to the name. It requires Secure and can't be
+	combined with Domain*/
+	HostPrefix bool `json:"host_prefix"`
+}
+
+/*Remember returns the cookie described by the config. Secure should be set whenever the
+site is served over HTTPS*/
+func (c Config) Remember(secure bool) (*Remember, error) {
+	rc := Remember{
+		cookie: Cookie{
+			name:   c.Name,
+			path:   "/",
+			domain: c.Domain,
+			secure: secure,
+		},
+		lifetime: time.Duration(c.LifetimeDays) * 24 * time.Hour,
+	}
+	if rc.cookie.name == "" {
+		rc.cookie.name = defaultName
+	}
+	if rc.lifetime <= 0 {
+		rc.lifetime = defaultLifetime
+	}
+	if c.HostPrefix {
+		if c.Domain != "" {
+			return nil, errors.New("cookies: the __Host- prefix can't be used with a domain")
+		}
+		if !secure {
+			return nil, errors.New("cookies: the __Host- prefix requires secure cookies")
+		}
+		rc.cookie.name = hostPrefix + rc.cookie.name
+	}
+	return &rc, nil
+}
+
+/*Remember sets and reads the cookie holding the session token*/
+type Remember struct {
+	cookie   Cookie
+	lifetime time.Duration
+}
+
+/*Lifetime is how long a persistent session lasts without being used*/
+func (rc *Remember) Lifetime() time.Duration {
+	return rc.lifetime
+}
+
+/*Token returns the session token the request came with*/
+func (rc *Remember) Token(r *http.Request) (string, error) {
+	return rc.cookie.Value(r)
+}
+
+/*Set hands the token over to the browser. A zero expiresAt sets a session cookie, which
+the browser forgets once it is closed*/
+func (rc *Remember) Set(w http.ResponseWriter, token string, expiresAt time.Time) {
+	rc.cookie.Set(w, token, expiresAt)
+}
+
+/*Clear removes the cookie from the browser*/
+func (rc *Remember) Clear(w http.ResponseWriter) {
+	rc.cookie.Clear(w)
+}
+
+/*Cookie returns another cookie that is as secure as the session cookie. It is only sent
+to this host and to the path*/
+func (rc *Remember) Cookie(name, path string) *Cookie {
+	return &Cookie{
+		name:   name,
+		path:   path,
+		secure: rc.cookie.secure,
+	}
+}
+
+/*Cookie is the one way cookies that sign users in, or take part in it, are built. They are
+HttpOnly and SameSite=Lax, so scripts can't read them and other sites can't send them along
+with their forms, and Secure whenever the site is served over HTTPS*/
+type Cookie struct {
+	name   string
+	path   string
+	domain string
+	secure bool
+}
+
+/*Value returns the value the request came with*/
+func (c *Cookie) Value(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(c.name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+/*Set hands the value over to the browser, a zero expiresAt sets a session cookie*/
+func (c *Cookie) Set(w http.ResponseWriter, value string, expiresAt time.Time) {
+	http.SetCookie(w, c.cookie(value, expiresAt))
+}
+
+/*Clear removes the cookie from the browser*/
+func (c *Cookie) Clear(w http.ResponseWriter) {
+	cookie := c.cookie("", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+func (c *Cookie) cookie(value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.name,
+		Value:    value,
+		Path:     c.path,
+		Domain:   c.domain,
+		Expires:  expiresAt,
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,88 @@
+package cookies
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfigRemember(t *testing.T) {
+	rc, err := Config{}.Remember(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.cookie.name != "remember_token" || rc.lifetime != 30*24*time.Hour {
+		t.Errorf("Expected the defaults, Received %q and %v", rc.cookie.name, rc.lifetime)
+	}
+	rc, err = Config{Name: "session", HostPrefix: true}.Remember(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.cookie.name != "__Host-session" {
+		t.Errorf("Expected the __Host- prefix, Received %q", rc.cookie.name)
+	}
+	if _, err := (Config{HostPrefix: true}).Remember(false); err == nil {
+		t.Error("Expected the __Host- prefix to require secure cookies")
+	}
+	if _, err := (Config{HostPrefix: true, Domain: "lenslocked.com"}).Remember(true); err == nil {
+		t.Error("Expected the __Host- prefix to reject a domain")
+	}
+}
+
+func TestSet(t *testing.T) {
+	rc, err := Config{LifetimeDays: 7}.Remember(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	rc.Set(w, "token", time.Time{})
+	rc.Set(w, "token", time.Now().Add(rc.Lifetime()))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies, Received %d", len(cookies))
+	}
+	if !cookies[0].Expires.IsZero() || cookies[1].Expires.IsZero() {
+		t.Error("Expected a session cookie and a persistent one")
+	}
+	for _, c := range cookies {
+		if !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+			t.Errorf("Expected a hardened cookie, Received %s", c)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[1])
+	if token, err := rc.Token(r); err != nil || token != "token" {
+		t.Errorf("Expected the token back, Received %q, %v", token, err)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	rc, err := Config{Domain: "lenslocked.com"}.Remember(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := rc.Cookie("mfa_token", "/signin")
+	w := httptest.NewRecorder()
+	c.Set(w, "token", time.Now().Add(time.Minute))
+	c.Clear(w)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies, Received %d", len(cookies))
+	}
+	for _, c := range cookies {
+		if !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/signin" || c.Domain != "" {
+			t.Errorf("Expected a hardened host-only cookie, Received %s", c)
+		}
+	}
+	if cookies[1].MaxAge >= 0 {
+		t.Errorf("Expected Clear to expire the cookie, Received %s", cookies[1])
+	}
+
+	r := httptest.NewRequest("GET", "/signin", nil)
+	r.AddCookie(cookies[0])
+	if value, err := c.Value(r); err != nil || value != "token" {
+		t.Errorf("Expected the value back, Received %q, %v", value, err)
+	}
+}
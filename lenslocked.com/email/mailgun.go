@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/mailgun/mailgun-go/v4"
+	"html"
 	"net/url"
 	"time"
 )
@@ -13,6 +14,30 @@ const (
 	welcomeSubject = "Welcome to LensLocked.com!"
 	resetSubject   = "Instructions for resetting a password"
 	resetBaseURL   = "https://lenslocked.com/reset"
+	verifySubject  = "Please verify your email address"
+	verifyBaseURL  = "https://lenslocked.com/verify"
+	signInSubject  = "Your sign-in link"
+	signInBaseURL  = "https://lenslocked.com/signin/link"
+	unlockSubject  = "Your account has been locked"
+	unlockBaseURL  = "https://lenslocked.com/unlock"
+
+	changeEmailSubject = "Please confirm your new email address"
+	changeEmailBaseURL = "https://lenslocked.com/account/email/confirm"
+	emailNoticeSubject = "Your email address is being changed"
+	revertEmailBaseURL = "https://lenslocked.com/account/email/revert"
+
+	inviteSubject = "You have been invited to LensLocked.com"
+	inviteBaseURL = "https://lenslocked.com/signup"
+
+	orgInviteSubject = "You have been invited to join an organization on LensLocked.com"
+	orgInviteBaseURL = "https://lenslocked.com/organizations/join"
+
+	registrationAttemptSubject = "Somebody tried to sign up with your email address"
+	signInURL                  = "https://lenslocked.com/signin"
+	recoveryURL                = "https://lenslocked.com/recovery"
+
+	newSignInSubject = "New sign-in to your LensLocked.com account"
+	loginHistoryURL  = "https://lenslocked.com/account/logins"
 
 	welcomeText = `Hi there!
 		Welcome to LensLocked.com! We really hope you enjoy using our application.
@@ -57,6 +82,258 @@ const (
 	`
 )
 
+const (
+	verifyTextTmpl = `Hi %s!
+		Please confirm that this is your email address by following the link below:
+
+		%s
+
+		If you didn't sign up for LensLocked.com you may safely ignore this email
+
+		Best,
+		LensLocked Support
+	`
+	verifyHTMLTmpl = `Hi %s!</br>
+		Please confirm that this is your email address by following the link below:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		If you didn't sign up for LensLocked.com you may safely ignore this email</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	signInTextTmpl = `Hi there!
+		Somebody asked for a link to sign in to LensLocked.com with this email address.
+		If this was you, please follow the link below within the next 15 minutes:
+
+		%s
+
+		The link can only be used once. If you didn't ask for it you may safely ignore this email
+
+		Best,
+		LensLocked Support
+	`
+	signInHTMLTmpl = `Hi there!</br>
+		Somebody asked for a link to sign in to LensLocked.com with this email address.
+		If this was you, please follow the link below within the next 15 minutes:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		The link can only be used once. If you didn't ask for it you may safely ignore this email</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	unlockTextTmpl = `Hi there!
+		There were too many failed attempts to sign in to your LensLocked.com account, so we
+		have locked it for the next hour. If this was you, you can unlock it right away with the link below:
+
+		%s
+
+		If it wasn't you, somebody may be guessing your password. The account stays locked for now,
+		you may want to reset your password once you are signed in again.
+
+		Best,
+		LensLocked Support
+	`
+	unlockHTMLTmpl = `Hi there!</br>
+		There were too many failed attempts to sign in to your LensLocked.com account, so we
+		have locked it for the next hour. If this was you, you can unlock it right away with the link below:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		If it wasn't you, somebody may be guessing your password. The account stays locked for now,
+		you may want to reset your password once you are signed in again.</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	changeEmailTextTmpl = `Hi %s!
+		You asked to use this address for your LensLocked.com account. Please confirm it by
+		following the link below within the next 24 hours:
+
+		%s
+
+		If you didn't ask for this you may safely ignore this email
+
+		Best,
+		LensLocked Support
+	`
+	changeEmailHTMLTmpl = `Hi %s!</br>
+		You asked to use this address for your LensLocked.com account. Please confirm it by
+		following the link below within the next 24 hours:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		If you didn't ask for this you may safely ignore this email</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+	emailNoticeTextTmpl = `Hi %s!
+		Somebody asked to change the email address of your LensLocked.com account to %s.
+		It changes once the new address has been confirmed.
+
+		If this wasn't you, follow the link below to keep this address and sign out everywhere:
+
+		%s
+
+		Best,
+		LensLocked Support
+	`
+	emailNoticeHTMLTmpl = `Hi %s!</br>
+		Somebody asked to change the email address of your LensLocked.com account to %s.
+		It changes once the new address has been confirmed.</br>
+		</br>
+		If this wasn't you, follow the link below to keep this address and sign out everywhere:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	inviteTextTmpl = `Hi there!
+		%s invited you to LensLocked.com. Follow the link below to create your account
+		before %s:
+
+		%s
+
+		Best,
+		LensLocked Support
+	`
+	inviteHTMLTmpl = `Hi there!</br>
+		%s invited you to LensLocked.com. Follow the link below to create your account
+		before %s:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	orgInviteTextTmpl = `Hi there!
+		%s invited you to join %s on LensLocked.com as %s. Follow the link below before %s
+		and sign in with this email address to accept:
+
+		%s
+
+		If you don't have an account yet, sign up with this email address first.
+
+		Best,
+		LensLocked Support
+	`
+	orgInviteHTMLTmpl = `Hi there!</br>
+		%s invited you to join %s on LensLocked.com as %s. Follow the link below before %s
+		and sign in with this email address to accept:</br>
+		</br>
+		<a href="%s">%s</a></br>
+		</br>
+		If you don't have an account yet, sign up with this email address first.</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	registrationAttemptText = `Hi there!
+		Somebody tried to create a LensLocked.com account with this email address, but you
+		already have one. If this was you, you can sign in here:
+
+		` + signInURL + `
+
+		Forgot your password? You can reset it here:
+
+		` + recoveryURL + `
+
+		If it wasn't you, you may safely ignore this email. Your account has not been changed.
+
+		Best,
+		LensLocked Support
+	`
+	registrationAttemptHTML = `Hi there!</br>
+		Somebody tried to create a LensLocked.com account with this email address, but you
+		already have one. If this was you, you can <a href="` + signInURL + `">sign in here</a>.</br>
+		</br>
+		Forgot your password? You can <a href="` + recoveryURL + `">reset it here</a>.</br>
+		</br>
+		If it wasn't you, you may safely ignore this email. Your account has not been changed.</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+const (
+	newSignInTextTmpl = `Hi %s!
+		Your LensLocked.com account was just signed in to from a device or browser we haven't seen before:
+
+		Time: %s
+		Browser: %s
+		IP address: %s
+
+		If this was you, there is nothing else to do. If it wasn't, reset your password right away
+		and sign out the other devices on your account page:
+
+		` + recoveryURL + `
+
+		You can review all recent sign-ins here:
+
+		` + loginHistoryURL + `
+
+		Best,
+		LensLocked Support
+	`
+	newSignInHTMLTmpl = `Hi %s!</br>
+		Your LensLocked.com account was just signed in to from a device or browser we haven't seen before:</br>
+		</br>
+		Time: %s</br>
+		Browser: %s</br>
+		IP address: %s</br>
+		</br>
+		If this was you, there is nothing else to do. If it wasn't, <a href="` + recoveryURL + `">reset your password</a>
+		right away and sign out the other devices on your account page.</br>
+		</br>
+		You can review all recent sign-ins <a href="` + loginHistoryURL + `">here</a>.</br>
+		</br>
+		Best,</br>
+		LensLocked Support</br>
+	`
+)
+
+/*Emailer is implemented by Client. Controllers depend on it so that tests can record the
+emails instead of sending them*/
+type Emailer interface {
+	Welcome(toName, toEmail string) error
+	RegistrationAttempt(toEmail string) error
+	ResetPw(toEmail, token string) error
+	Verify(toName, toEmail, token string) error
+	SignInLink(toEmail, token string) error
+	Unlock(toEmail, token string) error
+	ChangeEmail(toName, toEmail, token string) error
+	EmailChangeNotice(toName, toEmail, newEmail, token string) error
+	Invite(fromName, toEmail, code string, expiresAt time.Time) error
+	NewSignIn(toName, toEmail, userAgent, ip string, at time.Time) error
+	OrgInvite(fromName, orgName, role, toEmail, token string, expiresAt time.Time) error
+}
+
+var _ Emailer = &Client{}
+
 func WithSender(name, email string) ClientConfig {
 	return func(c *Client) {
 		c.from = buildEmail(name, email)
@@ -99,6 +376,20 @@ func (c *Client) Welcome(toName, toEmail string) error {
 	return err
 }
 
+/*RegistrationAttempt is sent instead of telling the person signing up that the address
+is taken, which would reveal who has an account*/
+func (c *Client) RegistrationAttempt(toEmail string) error {
+	message := c.mg.NewMessage(c.from, registrationAttemptSubject, registrationAttemptText, toEmail)
+	message.SetHtml(registrationAttemptHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
 func (c *Client) ResetPw(toEmail, token string) error {
 	v := url.Values{}
 	v.Set("token", token)
@@ -116,6 +407,150 @@ func (c *Client) ResetPw(toEmail, token string) error {
 	return err
 }
 
+func (c *Client) Verify(toName, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	verifyUrl := verifyBaseURL + "?" + v.Encode()
+	verifyText := fmt.Sprintf(verifyTextTmpl, toName, verifyUrl)
+	message := c.mg.NewMessage(c.from, verifySubject, verifyText, buildEmail(toName, toEmail))
+	verifyHTML := fmt.Sprintf(verifyHTMLTmpl, html.EscapeString(toName), verifyUrl, verifyUrl)
+	message.SetHtml(verifyHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+func (c *Client) SignInLink(toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	signInUrl := signInBaseURL + "?" + v.Encode()
+	signInText := fmt.Sprintf(signInTextTmpl, signInUrl)
+	message := c.mg.NewMessage(c.from, signInSubject, signInText, toEmail)
+	signInHTML := fmt.Sprintf(signInHTMLTmpl, signInUrl, signInUrl)
+	message.SetHtml(signInHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+func (c *Client) Unlock(toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	unlockUrl := unlockBaseURL + "?" + v.Encode()
+	unlockText := fmt.Sprintf(unlockTextTmpl, unlockUrl)
+	message := c.mg.NewMessage(c.from, unlockSubject, unlockText, toEmail)
+	unlockHTML := fmt.Sprintf(unlockHTMLTmpl, unlockUrl, unlockUrl)
+	message.SetHtml(unlockHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+/*ChangeEmail asks the user to confirm the address they want to switch to*/
+func (c *Client) ChangeEmail(toName, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	changeUrl := changeEmailBaseURL + "?" + v.Encode()
+	changeText := fmt.Sprintf(changeEmailTextTmpl, toName, changeUrl)
+	message := c.mg.NewMessage(c.from, changeEmailSubject, changeText, buildEmail(toName, toEmail))
+	changeHTML := fmt.Sprintf(changeEmailHTMLTmpl, html.EscapeString(toName), changeUrl, changeUrl)
+	message.SetHtml(changeHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+/*EmailChangeNotice tells the old address about the change and how to undo it*/
+func (c *Client) EmailChangeNotice(toName, toEmail, newEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	revertUrl := revertEmailBaseURL + "?" + v.Encode()
+	noticeText := fmt.Sprintf(emailNoticeTextTmpl, toName, newEmail, revertUrl)
+	message := c.mg.NewMessage(c.from, emailNoticeSubject, noticeText, buildEmail(toName, toEmail))
+	noticeHTML := fmt.Sprintf(emailNoticeHTMLTmpl, html.EscapeString(toName), html.EscapeString(newEmail),
+		revertUrl, revertUrl)
+	message.SetHtml(noticeHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+/*Invite sends an invitation code on behalf of another user*/
+func (c *Client) Invite(fromName, toEmail, code string, expiresAt time.Time) error {
+	v := url.Values{}
+	v.Set("invite", code)
+	inviteUrl := inviteBaseURL + "?" + v.Encode()
+	expires := expiresAt.Format("January 2, 2006")
+	inviteText := fmt.Sprintf(inviteTextTmpl, fromName, expires, inviteUrl)
+	message := c.mg.NewMessage(c.from, inviteSubject, inviteText, toEmail)
+	inviteHTML := fmt.Sprintf(inviteHTMLTmpl, html.EscapeString(fromName), expires, inviteUrl, inviteUrl)
+	message.SetHtml(inviteHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+/*OrgInvite sends an invitation to join an organization*/
+func (c *Client) OrgInvite(fromName, orgName, role, toEmail, token string, expiresAt time.Time) error {
+	v := url.Values{}
+	v.Set("token", token)
+	joinUrl := orgInviteBaseURL + "?" + v.Encode()
+	expires := expiresAt.Format("January 2, 2006")
+	inviteText := fmt.Sprintf(orgInviteTextTmpl, fromName, orgName, role, expires, joinUrl)
+	message := c.mg.NewMessage(c.from, orgInviteSubject, inviteText, toEmail)
+	inviteHTML := fmt.Sprintf(orgInviteHTMLTmpl, html.EscapeString(fromName), html.EscapeString(orgName),
+		role, expires, joinUrl, joinUrl)
+	message.SetHtml(inviteHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
+/*NewSignIn warns the user about a sign in from a device they never used before*/
+func (c *Client) NewSignIn(toName, toEmail, userAgent, ip string, at time.Time) error {
+	when := at.UTC().Format("January 2, 2006 15:04 MST")
+	signInText := fmt.Sprintf(newSignInTextTmpl, toName, when, userAgent, ip)
+	message := c.mg.NewMessage(c.from, newSignInSubject, signInText, buildEmail(toName, toEmail))
+	signInHTML := fmt.Sprintf(newSignInHTMLTmpl, html.EscapeString(toName), when,
+		html.EscapeString(userAgent), html.EscapeString(ip))
+	message.SetHtml(signInHTML)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, _, err := c.mg.Send(ctx, message)
+
+	return err
+}
+
 func buildEmail(name, email string) string {
 	if name == "" {
 		return email
@@ -4,24 +4,26 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
 )
 
 func NewHMAC(key string) HMAC {
-	mac := hmac.New(sha256.New, []byte(key))
 	return HMAC{
-		hmac: mac,
+		key: []byte(key),
 	}
 }
 
-// Hash will hash an input string using HMAC with the secret key provided when the HMAC object was created
+// Hash will hash an input string using HMAC with the secret key provided when the HMAC object was created.
+// Every call uses its own hash.Hash, so an HMAC can be shared by concurrent requests
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
-	return base64.URLEncoding.EncodeToString(b)
+	return base64.URLEncoding.EncodeToString(h.sum(input))
+}
+
+func (h HMAC) sum(input string) []byte {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
 }
 
 type HMAC struct {
-	hmac hash.Hash
+	key []byte
 }
@@ -0,0 +1,54 @@
+package hash
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHashConcurrent(t *testing.T) {
+	h := NewHMAC("secret-hmac-key")
+	want := h.Hash("remember-token")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got := h.Hash("remember-token"); got != want {
+					t.Errorf("Expected %s, Received %s", want, got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestKeyringHashes(t *testing.T) {
+	k, err := NewKeyring(Key{ID: "2", Secret: "new-key"}, Key{ID: "1", Secret: "old-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := k.Hashes("token")
+	if len(hashes) != 2 {
+		t.Fatalf("Expected 2 hashes, Received %d", len(hashes))
+	}
+	if hashes[0] != k.Hash("token") {
+		t.Errorf("Expected the active key first, Received %v", hashes)
+	}
+	if hashes[1] != NewHMAC("old-key").Hash("token") {
+		t.Errorf("Expected the retired key second, Received %v", hashes)
+	}
+	if k.ActiveID() != "2" {
+		t.Errorf("Expected active key 2, Received %s", k.ActiveID())
+	}
+}
+
+func TestKeyringRejectsBadKeys(t *testing.T) {
+	if _, err := NewKeyring(Key{ID: "1"}); err == nil {
+		t.Error("Expected an error for a key without a secret")
+	}
+	if _, err := NewKeyring(Key{ID: "1", Secret: "a"}, Key{ID: "1", Secret: "b"}); err == nil {
+		t.Error("Expected an error for a duplicate key ID")
+	}
+}
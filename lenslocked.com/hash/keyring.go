@@ -0,0 +1,55 @@
+package hash
+
+import "fmt"
+
+/*Key is a secret HMAC key. The ID names it in the config, so keys can be told apart
+without comparing secrets*/
+type Key struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+/*Keyring hashes with the active key and keeps retired keys around, so values hashed
+before a key rotation can still be looked up and hashed again with the active key*/
+type Keyring struct {
+	ids   []string
+	hmacs []HMAC
+}
+
+/*NewKeyring returns a keyring that hashes with active. The retired keys are only
+used to look values up*/
+func NewKeyring(active Key, retired ...Key) (*Keyring, error) {
+	k := &Keyring{}
+	seen := make(map[string]bool)
+	for _, key := range append([]Key{active}, retired...) {
+		if key.ID == "" || key.Secret == "" {
+			return nil, fmt.Errorf("hash: key %q needs an ID and a secret", key.ID)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("hash: key %q is in the keyring twice", key.ID)
+		}
+		seen[key.ID] = true
+		k.ids = append(k.ids, key.ID)
+		k.hmacs = append(k.hmacs, NewHMAC(key.Secret))
+	}
+	return k, nil
+}
+
+/*ActiveID is the ID of the key new values are hashed with*/
+func (k *Keyring) ActiveID() string {
+	return k.ids[0]
+}
+
+/*Hash hashes input with the active key*/
+func (k *Keyring) Hash(input string) string {
+	return k.hmacs[0].Hash(input)
+}
+
+/*Hashes returns the hash of input under every key, the one of the active key first*/
+func (k *Keyring) Hashes(input string) []string {
+	hashes := make([]string, len(k.hmacs))
+	for i, h := range k.hmacs {
+		hashes[i] = h.Hash(input)
+	}
+	return hashes
+}
@@ -0,0 +1,114 @@
+package hash
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenMalformed = errors.New("hash: token is malformed")
+	ErrTokenSignature = errors.New("hash: token signature is invalid")
+	ErrTokenPurpose   = errors.New("hash: token was issued for another purpose")
+	ErrTokenExpired   = errors.New("hash: token has expired")
+	ErrClaimsInvalid  = errors.New("hash: claims need a purpose and an expiry")
+)
+
+/*signContext keeps signatures apart from the hashes of stored tokens, which use the same keys*/
+const signContext = "signed-token:"
+
+/*Claims is what a signed token says. The token is readable by anyone who has it, so claims
+must not hold secrets*/
+type Claims struct {
+	/*Purpose binds the token to a single use, e.g. "share" or "unsubscribe"*/
+	Purpose string `json:"p"`
+	/*Subject is what the token is about, like a user or gallery ID*/
+	Subject   string    `json:"s,omitempty"`
+	ExpiresAt time.Time `json:"-"`
+	/*Nonce tells apart tokens with the same claims, e.g. to revoke one of them*/
+	Nonce string `json:"n,omitempty"`
+}
+
+/*payload is what gets signed. KeyID names the key, so tokens signed before a key
+rotation still verify with the retired key*/
+type payload struct {
+	Claims
+	Exp   int64  `json:"e"`
+	KeyID string `json:"k"`
+}
+
+var encoding = base64.RawURLEncoding
+
+/*Sign returns a stateless token carrying the claims, signed with the active key. The token
+is URL safe*/
+func (k *Keyring) Sign(c Claims) (string, error) {
+	if c.Purpose == "" || c.ExpiresAt.IsZero() {
+		return "", ErrClaimsInvalid
+	}
+	b, err := json.Marshal(payload{Claims: c, Exp: c.ExpiresAt.Unix(), KeyID: k.ActiveID()})
+	if err != nil {
+		return "", err
+	}
+	body := encoding.EncodeToString(b)
+	return body + "." + k.sign(0, body), nil
+}
+
+/*Verify checks the signature of the token and that it was issued for purpose and has not
+expired yet, and returns its claims*/
+func (k *Keyring) Verify(token, purpose string) (*Claims, error) {
+	return k.VerifyAt(token, purpose, time.Now())
+}
+
+/*VerifyAt is Verify at the provided time*/
+func (k *Keyring) VerifyAt(token, purpose string, now time.Time) (*Claims, error) {
+	body, sig := splitToken(token)
+	if body == "" || sig == "" {
+		return nil, ErrTokenMalformed
+	}
+	b, err := encoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var p payload
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	i := k.index(p.KeyID)
+	if i < 0 || !hmac.Equal([]byte(k.sign(i, body)), []byte(sig)) {
+		return nil, ErrTokenSignature
+	}
+	/*only trust the claims once the signature is known to be good*/
+	if p.Purpose != purpose {
+		return nil, ErrTokenPurpose
+	}
+	p.Claims.ExpiresAt = time.Unix(p.Exp, 0)
+	if !now.Before(p.Claims.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return &p.Claims, nil
+}
+
+func (k *Keyring) sign(i int, body string) string {
+	return encoding.EncodeToString(k.hmacs[i].sum(signContext + body))
+}
+
+/*index returns the position of the key with the id, -1 if the ring doesn't have it*/
+func (k *Keyring) index(id string) int {
+	for i, kid := range k.ids {
+		if kid == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func splitToken(token string) (string, string) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return "", ""
+	}
+	return token[:i], token[i+1:]
+}
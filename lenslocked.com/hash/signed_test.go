@@ -0,0 +1,122 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testingKeyring(t *testing.T, keys ...Key) *Keyring {
+	t.Helper()
+	if len(keys) == 0 {
+		keys = []Key{{ID: "1", Secret: "secret-hmac-key"}}
+	}
+	k, err := NewKeyring(keys[0], keys[1:]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSignVerify(t *testing.T) {
+	k := testingKeyring(t)
+	expiresAt := time.Now().Add(time.Hour)
+	token, err := k.Sign(Claims{Purpose: "share", Subject: "42", ExpiresAt: expiresAt, Nonce: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := k.Verify(token, "share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Purpose != "share" || c.Subject != "42" || c.Nonce != "abc" {
+		t.Errorf("Expected the signed claims, Received %+v", c)
+	}
+	if c.ExpiresAt.Unix() != expiresAt.Unix() {
+		t.Errorf("Expected expiry %v, Received %v", expiresAt, c.ExpiresAt)
+	}
+	if strings.ContainsAny(token, "+/= ") {
+		t.Errorf("Expected a URL safe token, Received %s", token)
+	}
+}
+
+func TestSignRequiresPurposeAndExpiry(t *testing.T) {
+	k := testingKeyring(t)
+	if _, err := k.Sign(Claims{ExpiresAt: time.Now().Add(time.Hour)}); err != ErrClaimsInvalid {
+		t.Errorf("Expected ErrClaimsInvalid without a purpose, Received %v", err)
+	}
+	if _, err := k.Sign(Claims{Purpose: "share"}); err != ErrClaimsInvalid {
+		t.Errorf("Expected ErrClaimsInvalid without an expiry, Received %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	k := testingKeyring(t)
+	now := time.Now()
+	token, err := k.Sign(Claims{Purpose: "unsubscribe", Subject: "7", ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, sig := splitToken(token)
+	forged, err := k.Sign(Claims{Purpose: "unsubscribe", Subject: "8", ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedBody, _ := splitToken(forged)
+	other := testingKeyring(t, Key{ID: "1", Secret: "another-key"})
+	foreign, err := other.Sign(Claims{Purpose: "unsubscribe", Subject: "7", ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		token   string
+		purpose string
+		at      time.Time
+		err     error
+	}{
+		{"empty", "", "unsubscribe", now, ErrTokenMalformed},
+		{"no signature", body, "unsubscribe", now, ErrTokenMalformed},
+		{"bad encoding", "!!!." + sig, "unsubscribe", now, ErrTokenMalformed},
+		{"bad payload", encoding.EncodeToString([]byte("nope")) + "." + sig, "unsubscribe", now, ErrTokenMalformed},
+		{"swapped payload", forgedBody + "." + sig, "unsubscribe", now, ErrTokenSignature},
+		{"truncated signature", body + "." + sig[:len(sig)-1], "unsubscribe", now, ErrTokenSignature},
+		{"other key", foreign, "unsubscribe", now, ErrTokenSignature},
+		{"other purpose", token, "share", now, ErrTokenPurpose},
+		{"expired", token, "unsubscribe", now.Add(time.Hour), ErrTokenExpired},
+	}
+	for _, c := range cases {
+		if _, err := k.VerifyAt(c.token, c.purpose, c.at); err != c.err {
+			t.Errorf("%s: Expected %v, Received %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	old := testingKeyring(t, Key{ID: "1", Secret: "old-key"})
+	token, err := old.Sign(Claims{Purpose: "verify_email", Subject: "3", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := testingKeyring(t, Key{ID: "2", Secret: "new-key"}, Key{ID: "1", Secret: "old-key"})
+	if _, err := rotated.Verify(token, "verify_email"); err != nil {
+		t.Errorf("Expected a token of a retired key to verify, Received %v", err)
+	}
+	dropped := testingKeyring(t, Key{ID: "2", Secret: "new-key"})
+	if _, err := dropped.Verify(token, "verify_email"); err != ErrTokenSignature {
+		t.Errorf("Expected ErrTokenSignature once the key is dropped, Received %v", err)
+	}
+}
+
+func TestSignaturesDifferFromHashes(t *testing.T) {
+	k := testingKeyring(t)
+	token, err := k.Sign(Claims{Purpose: "share", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, sig := splitToken(token)
+	if k.Hash(body) == sig {
+		t.Error("Expected the signature to differ from the plain hash of the payload")
+	}
+}
@@ -9,8 +9,12 @@ import (
 	"github.com/username/project-name/email"
 	"github.com/username/project-name/middleware"
 	"github.com/username/project-name/models"
+	"github.com/username/project-name/oidc"
 	"github.com/username/project-name/rand"
+	"github.com/username/project-name/views"
+	"log"
 	"net/http"
+	"time"
 )
 
 func main() {
@@ -20,11 +24,31 @@ func main() {
 	cfg := LoadConfig(*boolPtr)
 	dbCfg := cfg.Database
 	dbCfgInfo := dbCfg.ConnectionInfo()
+	keys, err := cfg.HMACKeyring()
+	must(err)
+	rememberCookie, err := cfg.Cookie.Remember(cfg.isProd())
+	must(err)
+	impersonationCookie := rememberCookie.Cookie("impersonation_token", "/")
+	alerts := views.NewAlerts(rememberCookie)
 	services, err := models.NewServices(
 		models.WithGorm(dbCfg.Dialect(dbCfgInfo)),
-		models.WithUser(cfg.HMACKey),
-		models.WithGallery(),
+		models.WithAttempts(cfg.LoginAttempts),
+		models.WithPasswordHashing(cfg.PasswordHashing),
+		models.WithPasswordPolicy(cfg.PasswordPolicy),
+		models.WithPasswordReset(cfg.PasswordReset),
+		models.WithUser(keys),
+		models.WithOrganization(keys),
+		models.WithPolicy(cfg.Verification),
+		models.WithSession(keys, rememberCookie.Lifetime()),
+		models.WithAPIToken(keys),
+		models.WithInvitation(keys, cfg.Registration),
+		models.WithIdentity(),
+		models.WithGallery(keys),
 		models.WithImage(),
+		models.WithAccount(),
+		models.WithAdmin(),
+		models.WithAudit(),
+		models.WithLoginEvent(keys),
 	)
 	must(err)
 	//services.ResetDB()
@@ -36,24 +60,71 @@ func main() {
 		email.WithMailgun(mgCfg.Domain, mgCfg.APIKey),
 	)
 
+	providers := make([]*oidc.Provider, 0, len(cfg.OIDC))
+	for _, pCfg := range cfg.OIDC {
+		providers = append(providers, oidc.NewProvider(pCfg))
+	}
+
 	r := mux.NewRouter()
 
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User, emailer)
+	usersC := controllers.NewUsers(services.User, services.Session, services.Identity, services.Invitation,
+		services.LoginEvent, providers, rememberCookie, emailer)
+	accountC := controllers.NewAccount(services.User, services.Session, services.Identity, services.Account,
+		services.LoginEvent, providers, emailer)
+	apiTokensC := controllers.NewAPITokens(services.APIToken)
+	invitationsC := controllers.NewInvitations(services.Invitation, services.Policy, emailer)
 
-	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, r)
+	profilesC := controllers.NewProfiles(services.User, services.Gallery, services.Image, services.Policy)
+	adminC := controllers.NewAdmin(services.User, services.Session, services.Gallery, services.Image,
+		services.Admin, services.Audit, impersonationCookie, emailer)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, services.Organization,
+		services.Policy, r)
+	orgsC := controllers.NewOrganizations(services.Organization, services.Gallery, services.Policy, emailer)
 
 	/*middleware*/
 	n, err := rand.Bytes(32)
 	must(err)
-	csrfMw := csrf.Protect(n, csrf.Secure(cfg.isProd()))
+	csrfMw := csrf.Protect(n, csrf.Secure(cfg.isProd()), csrf.SameSite(csrf.SameSiteLaxMode))
 
 	UserMw := middleware.User{
-		UserService: services.User,
+		UserService:    services.User,
+		SessionService: services.Session,
+		Cookie:         rememberCookie,
+		Impersonation:  impersonationCookie,
 	}
 
 	requireUserMw := middleware.RequireUser{
-		User: UserMw,
+		User:        UserMw,
+		ReauthAfter: cfg.reauthAfter(),
+	}
+
+	authorizeMw := middleware.Authorize{
+		Policy: services.Policy,
+	}
+	/*gallery wraps handlers of a single gallery with the permission check*/
+	gallery := func(perm models.Permission, next http.HandlerFunc) http.HandlerFunc {
+		return authorizeMw.RequirePermission(perm, galleriesC.LoadGallery, next)
+	}
+
+	/*organization wraps handlers of a single organization with the permission check*/
+	organization := func(perm models.Permission, next http.HandlerFunc) http.HandlerFunc {
+		return requireUserMw.ApplyFn(authorizeMw.RequirePermission(perm, orgsC.LoadOrganization, next))
+	}
+
+	/*admin guards the admin console*/
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return requireUserMw.ApplyFn(authorizeMw.RequireRole(models.RoleAdmin, next))
+	}
+	/*adminSensitive guards the admin actions that act on behalf of or against a user, they
+	need a recent sign in like the sensitive account pages*/
+	adminSensitive := func(next http.HandlerFunc) http.HandlerFunc {
+		return admin(requireUserMw.RecentAuthFn(next))
+	}
+
+	apiTokenMw := middleware.APIToken{
+		UserService:  services.User,
+		TokenService: services.APIToken,
 	}
 
 	r.Handle("/", staticC.Home).Methods("GET")
@@ -61,38 +132,142 @@ func main() {
 	r.HandleFunc("/signup", usersC.New).Methods("GET")
 	r.Handle("/signup", usersC.NewView).Methods("GET")
 	r.HandleFunc("/signup", usersC.Create).Methods("POST")
-	r.Handle("/signin", usersC.LoginView).Methods("GET")
+	r.HandleFunc("/signin", usersC.SignIn).Methods("GET")
 	r.HandleFunc("/signin", usersC.Login).Methods("POST")
+	r.HandleFunc("/signin/link", usersC.SendSignInLink).Methods("POST")
+	r.HandleFunc("/signin/link", usersC.SignInLink).Methods("GET")
+	r.HandleFunc("/signin/link/complete", usersC.CompleteSignInLink).Methods("POST")
+	r.Handle("/signin/2fa", usersC.TwoFactorView).Methods("GET")
+	r.HandleFunc("/signin/2fa", usersC.CompleteTwoFactor).Methods("POST")
 	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
 	r.Handle("/recovery", usersC.ForgotPwView).Methods("GET")
 	r.HandleFunc("/recovery", usersC.InitiateReset).Methods("POST")
 	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
 	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
+	/*signed in users link the provider account instead of signing in. The callback only
+	finishes the flow for the user who started it, so the check is on the way out*/
+	r.HandleFunc("/oauth/{provider}/signin", requireUserMw.SensitiveForUsersFn(usersC.OAuthSignIn)).Methods("GET")
+	r.HandleFunc("/oauth/{provider}/confirm", requireUserMw.ApplyFn(usersC.OAuthConfirm)).Methods("GET")
+	r.HandleFunc("/oauth/{provider}/callback", usersC.OAuthCallback).Methods("GET")
+	r.HandleFunc("/verify", usersC.Verify).Methods("GET")
+	r.HandleFunc("/unlock", usersC.Unlock).Methods("GET")
+	r.HandleFunc("/confirm", requireUserMw.ApplyFn(usersC.Confirm)).Methods("GET")
+	r.HandleFunc("/confirm", requireUserMw.ApplyFn(usersC.CompleteConfirm)).Methods("POST")
+	r.HandleFunc("/verify/resend", requireUserMw.ApplyFn(usersC.ResendVerification)).Methods("POST")
+
+	/*Account routes*/
+	r.HandleFunc("/account", requireUserMw.ApplyFn(accountC.Index)).Methods("GET")
+	r.HandleFunc("/account/logins", requireUserMw.ApplyFn(accountC.Logins)).Methods("GET")
+	r.HandleFunc("/account/sessions/{id:[0-9]+}/delete",
+		requireUserMw.ApplySensitiveFn(accountC.RevokeSession)).Methods("POST")
+	r.HandleFunc("/account/sessions/delete-others",
+		requireUserMw.ApplySensitiveFn(accountC.RevokeOtherSessions)).Methods("POST")
+	r.HandleFunc("/account/identities/{id:[0-9]+}/delete",
+		requireUserMw.ApplySensitiveFn(usersC.OAuthUnlink)).Methods("POST")
+	r.HandleFunc("/account/2fa/enroll", requireUserMw.ApplySensitiveFn(accountC.EnrollTwoFactor)).Methods("POST")
+	r.HandleFunc("/account/2fa/confirm", requireUserMw.ApplySensitiveFn(accountC.ConfirmTwoFactor)).Methods("POST")
+	r.HandleFunc("/account/2fa/disable", requireUserMw.ApplySensitiveFn(accountC.DisableTwoFactor)).Methods("POST")
+	r.HandleFunc("/account/export", requireUserMw.ApplySensitiveFn(accountC.Export)).Methods("GET")
+	r.HandleFunc("/account/delete", requireUserMw.ApplySensitiveFn(accountC.RequestDeletion)).Methods("POST")
+	r.HandleFunc("/account/delete/cancel", requireUserMw.ApplySensitiveFn(accountC.CancelDeletion)).Methods("POST")
+	r.HandleFunc("/account/email", requireUserMw.ApplySensitiveFn(accountC.ChangeEmail)).Methods("POST")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(apiTokensC.Index)).Methods("GET")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplySensitiveFn(apiTokensC.Create)).Methods("POST")
+	r.HandleFunc("/account/tokens/{id:[0-9]+}/delete", requireUserMw.ApplySensitiveFn(apiTokensC.Delete)).Methods("POST")
+	r.HandleFunc("/account/invitations", requireUserMw.ApplyFn(invitationsC.Index)).Methods("GET")
+	r.HandleFunc("/account/invitations", requireUserMw.ApplyFn(invitationsC.Create)).Methods("POST")
+	r.HandleFunc("/account/invitations/{id:[0-9]+}/delete",
+		requireUserMw.ApplyFn(invitationsC.Delete)).Methods("POST")
+	r.HandleFunc("/account/profile", requireUserMw.ApplyFn(profilesC.Edit)).Methods("GET")
+	r.HandleFunc("/account/profile", requireUserMw.ApplyFn(profilesC.Update)).Methods("POST")
+	r.HandleFunc("/account/profile/avatar", requireUserMw.ApplyFn(profilesC.UploadAvatar)).Methods("POST")
+	r.HandleFunc("/account/profile/avatar/delete", requireUserMw.ApplyFn(profilesC.DeleteAvatar)).Methods("POST")
+	r.HandleFunc("/account/email/confirm", accountC.ConfirmEmailChange).Methods("GET")
+	r.HandleFunc("/account/email/revert", accountC.RevertEmail).Methods("GET")
+	r.HandleFunc("/account/email/revert", accountC.CompleteRevertEmail).Methods("POST")
+
+	r.HandleFunc("/u/{username}", profilesC.Show).Methods("GET")
+
+	/*Organization routes*/
+	r.HandleFunc("/organizations", requireUserMw.ApplyFn(orgsC.Index)).Methods("GET")
+	r.HandleFunc("/organizations", requireUserMw.ApplyFn(orgsC.Create)).Methods("POST")
+	r.HandleFunc("/organizations/join", requireUserMw.ApplyFn(orgsC.Join)).Methods("GET")
+	r.HandleFunc("/organizations/join", requireUserMw.ApplyFn(orgsC.CompleteJoin)).Methods("POST")
+	r.HandleFunc("/organizations/{id:[0-9]+}", organization(models.PermOrgView, orgsC.Show)).Methods("GET")
+	r.HandleFunc("/organizations/{id:[0-9]+}/invitations",
+		organization(models.PermOrgManage, orgsC.Invite)).Methods("POST")
+	r.HandleFunc("/organizations/{id:[0-9]+}/members/{userID:[0-9]+}/delete",
+		organization(models.PermOrgView, orgsC.RemoveMember)).Methods("POST")
+
+	/*Admin routes*/
+	r.HandleFunc("/admin", admin(adminC.Users)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", admin(adminC.User)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/suspend", adminSensitive(adminC.Suspend)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/unsuspend", admin(adminC.Unsuspend)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/reset-password", adminSensitive(adminC.ResetPassword)).Methods("POST")
+	r.HandleFunc("/admin/galleries/{id:[0-9]+}/delete", adminSensitive(adminC.DeleteGallery)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/impersonate", adminSensitive(adminC.Impersonate)).Methods("POST")
+	r.HandleFunc("/admin/impersonation/stop", requireUserMw.ApplyFn(adminC.StopImpersonating)).Methods("POST")
 
 	/*Assets*/
-	assetsHandler := http.FileServer(http.Dir("./assets"))
-	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", assetsHandler))
-	/*Image routes*/
-	imageHandler := http.FileServer(http.Dir("./images/"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
+	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", controllers.Files("./assets")))
+	/*Image routes, gallery images are only served to those who may see the gallery*/
+	galleriesC.ImageRoutes(r, "./images", func(next http.HandlerFunc) http.HandlerFunc {
+		return requireUserMw.ScopeFn(models.ScopeGalleriesRead, next)
+	})
 
 	/*Gallery routes*/
-	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET")
-	r.Handle("/galleries/new", requireUserMw.Apply(galleriesC.New)).Methods("GET")
-	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name("show_gallery")
-	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET").Name(
-		"edit_gallery")
-	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
-
-	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete",
-		requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
+	r.HandleFunc("/galleries",
+		requireUserMw.ApplyScopeFn(models.ScopeGalleriesRead, galleriesC.Index)).Methods("GET")
+	r.HandleFunc("/galleries/new", requireUserMw.ApplyFn(galleriesC.New)).Methods("GET")
+	r.HandleFunc("/galleries",
+		requireUserMw.ApplyScopeFn(models.ScopeGalleriesWrite, galleriesC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}", requireUserMw.ScopeFn(models.ScopeGalleriesRead,
+		gallery(models.PermGalleryView, galleriesC.Show))).Methods("GET").Name("show_gallery")
+	r.HandleFunc("/g/{slug}", authorizeMw.RequirePermission(models.PermGalleryViewLink,
+		galleriesC.LoadGalleryBySlug, galleriesC.ShowShared)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit",
+		requireUserMw.ApplyFn(gallery(models.PermGalleryEdit, galleriesC.Edit))).Methods("GET").Name("edit_gallery")
+	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyScopeFn(models.ScopeGalleriesWrite,
+		gallery(models.PermGalleryEdit, galleriesC.Update))).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyScopeFn(models.ScopeGalleriesWrite,
+		requireUserMw.RecentAuthFn(gallery(models.PermGalleryDelete, galleriesC.Delete)))).Methods("POST")
+
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyScopeFn(models.ScopeImagesWrite,
+		gallery(models.PermImageDelete, galleriesC.ImageDelete))).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyScopeFn(models.ScopeImagesWrite,
+		gallery(models.PermImageUpload, galleriesC.ImageUpload))).Methods("POST")
+
+	go every(time.Hour, func() {
+		if _, err := services.Account.PurgeDeleted(); err != nil {
+			log.Println("purging deleted accounts:", err)
+		}
+		if err := services.User.PurgeExpiredTokens(); err != nil {
+			log.Println("purging expired tokens:", err)
+		}
+		if err := services.Organization.PurgeInvitations(); err != nil {
+			log.Println("purging organization invitations:", err)
+		}
+		if err := services.LoginEvent.Purge(time.Now().Add(-loginHistoryRetention)); err != nil {
+			log.Println("purging login history:", err)
+		}
+	})
 
 	fmt.Printf("The server is running on :%d...\n", cfg.Port)
-	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), csrfMw(UserMw.Apply(r)))
+	/*requests with an API token skip both the CSRF check and the session cookie*/
+	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), alerts.Apply(apiTokenMw.Apply(r, csrfMw(UserMw.Apply(r)))))
+
+}
+
+/*loginHistoryRetention is how long sign in attempts are kept*/
+const loginHistoryRetention = 90 * 24 * time.Hour
 
+/*every runs fn right away and then once per interval*/
+func every(interval time.Duration, fn func()) {
+	for {
+		fn()
+		time.Sleep(interval)
+	}
 }
 
 func must(err error) {
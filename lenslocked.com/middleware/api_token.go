@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/models"
+	"net/http"
+	"strings"
+)
+
+/*APIToken authenticates requests that carry an "Authorization: Bearer" header. Those requests
+never look at the session cookie, so they can skip the CSRF check that protects the cookie*/
+type APIToken struct {
+	models.UserService
+	TokenService models.APITokenService
+}
+
+/*Apply sends requests with a valid token straight to api and any request without a
+token to browser. A token that is not valid is rejected rather than falling back to the cookie*/
+func (mw *APIToken) Apply(api, browser http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := bearerToken(r)
+		if !ok {
+			browser.ServeHTTP(w, r)
+			return
+		}
+		token, err := mw.TokenService.Authenticate(raw)
+		if err != nil {
+			unauthorized(w)
+			return
+		}
+		user, err := mw.ByID(token.UserID)
+		if err != nil || user.Suspended() {
+			unauthorized(w)
+			return
+		}
+		ctx := r.Context()
+		ctx = context.WithUser(ctx, user)
+		ctx = context.WithAPIToken(ctx, token)
+		api.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, "Invalid API token", http.StatusUnauthorized)
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/models"
+	"net/http"
+)
+
+/*Authorize asks the central policy whether the current user may continue. Handlers
+behind it don't check ownership themselves*/
+type Authorize struct {
+	Policy *models.Policy
+}
+
+/*Loader looks up the resource a request targets, it returns models.ErrNotFound when
+there is none*/
+type Loader func(r *http.Request) (interface{}, error)
+
+/*RequireRole lets users with the role or a higher one through*/
+func (mw *Authorize) RequireRole(role models.Role, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !context.User(r.Context()).HasRole(role) {
+			http.Error(w, "You are not allowed to see this page", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+/*RequirePermission loads the resource with load, pass nil for permissions that don't
+target one, and checks the permission against it. The resource is handed to next in the
+request context. Denied requests for a resource get the same 404 as missing ones so
+that IDs can't be probed*/
+func (mw *Authorize) RequirePermission(perm models.Permission, load Loader, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resource interface{}
+		if load != nil {
+			var err error
+			resource, err = load(r)
+			switch err {
+			case nil:
+			case models.ErrNotFound:
+				http.Error(w, "Not found", http.StatusNotFound)
+				return
+			default:
+				http.Error(w, "Oops, something went wrong", http.StatusInternalServerError)
+				return
+			}
+		}
+		if !mw.Policy.Can(context.User(r.Context()), perm, resource) {
+			if resource != nil {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "You are not allowed to do this", http.StatusForbidden)
+			}
+			return
+		}
+		if resource != nil {
+			r = r.WithContext(context.WithResource(r.Context(), resource))
+		}
+		next(w, r)
+	})
+}
@@ -2,13 +2,21 @@ package middleware
 
 import (
 	"github.com/username/project-name/context"
+	"github.com/username/project-name/cookies"
 	"github.com/username/project-name/models"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 type User struct {
 	models.UserService
+	SessionService models.SessionService
+	/*Cookie holds the session token, persistent cookies are renewed along with their session*/
+	Cookie *cookies.Remember
+	/*Impersonation holds the token of the session an admin is signed in as a user with*/
+	Impersonation *cookies.Cookie
 }
 
 func (mw *User) Apply(next http.Handler) http.HandlerFunc {
@@ -18,42 +26,87 @@ func (mw *User) Apply(next http.Handler) http.HandlerFunc {
 func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		/*if the user is requesting a static asset or image we won't need to look up
-		the current user in the database. We skip the step*/
+		/*if the user is requesting a static asset or avatar we won't need to look up
+		the current user in the database. We skip the step. Gallery images are only
+		served to those who may see the gallery, so they need the user*/
 		path := r.URL.Path
 
 		if strings.HasPrefix(path, "/assets/") ||
-			strings.HasPrefix(path, "/images/") {
+			strings.HasPrefix(path, "/images/avatars/") {
 			next(w, r)
 			return
 		}
 
-		cookie, err := r.Cookie("remember_token")
+		token, err := mw.Cookie.Token(r)
 		if err != nil {
 			next(w, r)
 			return
 		}
-		user, err := mw.ByRemember(cookie.Value)
+		session, err := mw.SessionService.ByToken(token)
+		if err != nil || session.Impersonated() {
+			next(w, r)
+			return
+		}
+		user, err := mw.ByID(session.UserID)
 		if err != nil {
 			next(w, r)
 			return
 		}
+		/*suspended users are treated as signed out, their sessions are gone once
+		the suspension is lifted*/
+		if user.Suspended() {
+			mw.SessionService.Delete(session.ID)
+			next(w, r)
+			return
+		}
+		if renewed, _ := mw.SessionService.Touch(session); renewed && session.Persistent {
+			mw.Cookie.Set(w, token, session.ExpiresAt)
+		}
+
 		ctx := r.Context()
 		ctx = context.WithUser(ctx, user)
+		ctx = context.WithSession(ctx, session)
+		if target := mw.impersonated(r, user); target != nil {
+			ctx = context.WithUser(ctx, target)
+			ctx = context.WithImpersonator(ctx, user)
+		}
 		r = r.WithContext(ctx)
 
 		next(w, r)
 	})
 }
 
+/*impersonated returns the user an admin is signed in as. The impersonation session has
+to be opened by the admin of the own session, so it ends when the admin signs out.
+Suspended users can't be acted as, like they can't sign in*/
+func (mw *User) impersonated(r *http.Request, admin *models.User) *models.User {
+	token, err := mw.Impersonation.Value(r)
+	if err != nil || !admin.HasRole(models.RoleAdmin) {
+		return nil
+	}
+	session, err := mw.SessionService.ByToken(token)
+	if err != nil || session.ImpersonatorID != admin.ID {
+		return nil
+	}
+	target, err := mw.ByID(session.UserID)
+	if err != nil || target.Suspended() {
+		return nil
+	}
+	return target
+}
+
 type RequireUser struct {
 	User
+	/*ReauthAfter is how long a sign in counts as recent for RecentAuthFn*/
+	ReauthAfter time.Duration
 }
 
 func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
 	return mw.ApplyFn(next.ServeHTTP)
 }
 
+/*ApplyFn only lets browser sessions through, use ApplyScopeFn for pages that
+API tokens may use as well*/
 func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := context.User(r.Context())
@@ -61,6 +114,93 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 			http.Redirect(w, r, "/signup", http.StatusFound)
 			return
 		}
+		if context.APIToken(r.Context()) != nil {
+			http.Error(w, "This page cannot be used with an API token", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+/*ApplySensitiveFn is ApplyFn for pages that change how the user signs in or what happens
+to the account. Admins signed in as the user can't use them and the user has to have
+signed in recently, see RecentAuthFn*/
+func (mw *RequireUser) ApplySensitiveFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		if context.Impersonator(r.Context()) != nil {
+			http.Error(w, "This is not available while signed in as another user", http.StatusForbidden)
+			return
+		}
+		mw.RecentAuthFn(next)(w, r)
+	})
+}
+
+/*SensitiveForUsersFn applies ApplySensitiveFn to signed in users and lets everybody else
+through. It is for pages that sign visitors in but change how signed in users sign in*/
+func (mw *RequireUser) SensitiveForUsersFn(next http.HandlerFunc) http.HandlerFunc {
+	sensitive := mw.ApplySensitiveFn(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			next(w, r)
+			return
+		}
+		sensitive(w, r)
+	})
+}
+
+/*RecentAuthFn sends sessions that haven't proved who they are within ReauthAfter to the
+confirmation page, so a stolen cookie alone isn't enough. The page sends the user back to
+where the request came from. Requests with an API token have no session and pass*/
+func (mw *RequireUser) RecentAuthFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.APIToken(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+		if session := context.Session(r.Context()); session != nil && session.AuthenticatedWithin(mw.ReauthAfter) {
+			next(w, r)
+			return
+		}
+		v := url.Values{}
+		v.Set("next", returnPath(r))
+		http.Redirect(w, r, "/confirm?"+v.Encode(), http.StatusFound)
+	})
+}
+
+/*returnPath is the page to come back to after confirming. A form can't be submitted again
+for the user, so that is the page the form was on*/
+func returnPath(r *http.Request) string {
+	if r.Method == http.MethodGet {
+		return r.URL.RequestURI()
+	}
+	ref, err := url.Parse(r.Referer())
+	if err != nil || ref.Host != r.Host || ref.Path == "" {
+		return "/account"
+	}
+	return ref.RequestURI()
+}
+
+/*ApplyScopeFn also accepts API tokens that were granted the scope*/
+func (mw *RequireUser) ApplyScopeFn(scope string, next http.HandlerFunc) http.HandlerFunc {
+	scoped := mw.ScopeFn(scope, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			http.Redirect(w, r, "/signup", http.StatusFound)
+			return
+		}
+		scoped(w, r)
+	})
+}
+
+/*ScopeFn rejects API tokens that weren't granted the scope and lets everybody else through.
+It is for pages visitors may see as well, the token owner would otherwise get them with
+any token*/
+func (mw *RequireUser) ScopeFn(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := context.APIToken(r.Context()); token != nil && !token.HasScope(scope) {
+			http.Error(w, "The API token lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
 		next(w, r)
 	})
 }
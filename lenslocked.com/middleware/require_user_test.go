@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"github.com/username/project-name/context"
+	"github.com/username/project-name/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/*TestScopeFn covers pages visitors may see as well, like a single gallery. The owner of an
+API token only gets to them with the scope*/
+func TestScopeFn(t *testing.T) {
+	user := &models.User{}
+	tests := []struct {
+		name  string
+		user  *models.User
+		token *models.APIToken
+		want  int
+	}{
+		{"visitor", nil, nil, http.StatusOK},
+		{"browser session", user, nil, http.StatusOK},
+		{"token without the scope", user, &models.APIToken{Scopes: models.ScopeImagesWrite}, http.StatusForbidden},
+		{"token with the scope", user, &models.APIToken{Scopes: models.ScopeGalleriesRead}, http.StatusOK},
+	}
+	mw := &RequireUser{}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/galleries/1", nil)
+		ctx := req.Context()
+		if tt.user != nil {
+			ctx = context.WithUser(ctx, tt.user)
+		}
+		if tt.token != nil {
+			ctx = context.WithAPIToken(ctx, tt.token)
+		}
+		w := httptest.NewRecorder()
+		mw.ScopeFn(models.ScopeGalleriesRead, ok)(w, req.WithContext(ctx))
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package models
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"time"
+)
+
+/*deletionGracePeriod is how long a user can still change their mind after asking for their account to be deleted*/
+const deletionGracePeriod = 14 * 24 * time.Hour
+
+/*DeletionPending reports whether the user asked for the account to be deleted*/
+func (u *User) DeletionPending() bool {
+	return u.DeletionRequestedAt != nil
+}
+
+/*DeletesAt is when the account will be purged, it is only meaningful while DeletionPending*/
+func (u *User) DeletesAt() time.Time {
+	if u.DeletionRequestedAt == nil {
+		return time.Time{}
+	}
+	return u.DeletionRequestedAt.Add(deletionGracePeriod)
+}
+
+/*RequestDeletion schedules the account for deletion once the grace period is over.
+Users who never set a password only need to be signed in*/
+func (us *userService) RequestDeletion(user *User, pw string) error {
+	if user.DeletionPending() {
+		return ErrDeletionPending
+	}
+	if user.PasswordHash != "" {
+		if len(pw) == 0 {
+			return ErrPasswordIsRequired
+		}
+		if err := us.checkPassword(user, pw); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	user.DeletionRequestedAt = &now
+	return us.Update(user)
+}
+
+func (us *userService) CancelDeletion(user *User) error {
+	if !user.DeletionPending() {
+		return nil
+	}
+	user.DeletionRequestedAt = nil
+	return us.Update(user)
+}
+
+/*AccountService works on everything that belongs to a user at once*/
+type AccountService interface {
+	/*Export writes a ZIP with a manifest.json describing the user, their galleries and
+	images, followed by the original image files*/
+	Export(user *User, w io.Writer) error
+	/*PurgeDeleted deletes the accounts whose grace period is over and returns how many*/
+	PurgeDeleted() (int, error)
+	/*Purge deletes the user with all galleries, images and any other rows tied to the user*/
+	Purge(user *User) error
+	/*Deletable returns ErrSoleOwner while the user is the only owner of an organization*/
+	Deletable(user *User) error
+}
+
+func NewAccountService(db *gorm.DB, gs GalleryService, is ImageService, orgs OrganizationService) AccountService {
+	return &accountService{
+		db:   db,
+		gs:   gs,
+		is:   is,
+		orgs: orgs,
+	}
+}
+
+var _ AccountService = &accountService{}
+
+type accountService struct {
+	db   *gorm.DB
+	gs   GalleryService
+	is   ImageService
+	orgs OrganizationService
+}
+
+/*userOwnedRows lists the tables with a user_id column, they are purged along with the user.
+Memberships are left by Purge first, so that no organization loses its last owner*/
+func userOwnedRows() []interface{} {
+	return []interface{}{
+		&Session{}, &pwReset{}, &recoveryCode{}, &mfaChallenge{}, &userToken{}, &Identity{}, &APIToken{},
+		&LoginEvent{}, &Membership{},
+	}
+}
+
+/*exportManifest is the manifest.json of an export*/
+type exportManifest struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	User       exportUser       `json:"user"`
+	Galleries  []exportGallery  `json:"galleries"`
+	Identities []exportIdentity `json:"identities"`
+	Sessions   []exportSession  `json:"sessions"`
+}
+
+type exportUser struct {
+	ID               uint       `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	Username         string     `json:"username"`
+	Bio              string     `json:"bio"`
+	Links            []string   `json:"links"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type exportGallery struct {
+	ID        uint          `json:"id"`
+	Title     string        `json:"title"`
+	CreatedAt time.Time     `json:"created_at"`
+	Images    []exportImage `json:"images"`
+}
+
+type exportImage struct {
+	Filename string `json:"filename"`
+	/*File is the path of the image within the ZIP*/
+	File string `json:"file"`
+}
+
+type exportIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportSession struct {
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+func (as *accountService) Export(user *User, w io.Writer) error {
+	manifest := exportManifest{
+		ExportedAt: time.Now(),
+		User: exportUser{
+			ID:               user.ID,
+			Name:             user.Name,
+			Email:            user.Email,
+			Username:         user.Username,
+			Bio:              user.Bio,
+			Links:            user.LinkList(),
+			EmailVerifiedAt:  user.EmailVerifiedAt,
+			TwoFactorEnabled: user.TwoFactorEnabled(),
+			CreatedAt:        user.CreatedAt,
+		},
+		Galleries:  []exportGallery{},
+		Identities: []exportIdentity{},
+		Sessions:   []exportSession{},
+	}
+
+	galleries, err := as.gs.ByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	var images []Image
+	for _, g := range galleries {
+		eg := exportGallery{
+			ID:        g.ID,
+			Title:     g.Title,
+			CreatedAt: g.CreatedAt,
+			Images:    []exportImage{},
+		}
+		imgs, err := as.is.ByGalleryID(g.ID)
+		if err != nil {
+			return err
+		}
+		for _, img := range imgs {
+			eg.Images = append(eg.Images, exportImage{
+				Filename: img.Filename,
+				File:     exportImagePath(img),
+			})
+		}
+		images = append(images, imgs...)
+		manifest.Galleries = append(manifest.Galleries, eg)
+	}
+
+	var identities []Identity
+	if err := as.db.Where("user_id = ?", user.ID).Find(&identities).Error; err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		manifest.Identities = append(manifest.Identities, exportIdentity{
+			Provider:  identity.Provider,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+	var sessions []Session
+	if err := as.db.Where("user_id = ?", user.ID).Find(&sessions).Error; err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		manifest.Sessions = append(manifest.Sessions, exportSession{
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+		})
+	}
+
+	zw := zip.NewWriter(w)
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	for _, img := range images {
+		if err := addExportImage(zw, img); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func exportImagePath(img Image) string {
+	return fmt.Sprintf("galleries/%d/%s", img.GalleryID, img.Filename)
+}
+
+func addExportImage(zw *zip.Writer, img Image) error {
+	f, err := os.Open(img.RelativePath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	/*images are already compressed, storing them saves time without growing the ZIP*/
+	iw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   exportImagePath(img),
+		Method: zip.Store,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(iw, f)
+	return err
+}
+
+/*PurgeDeleted skips the accounts of sole owners, they are purged once their organizations
+got another owner*/
+func (as *accountService) PurgeDeleted() (int, error) {
+	var users []User
+	err := as.db.Where("deletion_requested_at < ?", time.Now().Add(-deletionGracePeriod)).Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range users {
+		switch err := as.Purge(&users[i]); err {
+		case nil:
+			n++
+		case ErrSoleOwner:
+		default:
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (as *accountService) Deletable(user *User) error {
+	memberships, err := as.orgs.MembershipsByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, m := range memberships {
+		if m.Role != OrgOwner {
+			continue
+		}
+		owners, err := as.orgs.CountOwners(m.OrganizationID)
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return ErrSoleOwner
+		}
+	}
+	return nil
+}
+
+/*Purge leaves the organizations of the user and removes the image files first. Should
+the database part fail afterwards the user is still there and the next run picks it up
+again. Galleries deleted earlier are included in case their images were left behind.
+Galleries the user created for an organization stay with the organization*/
+func (as *accountService) Purge(user *User) error {
+	memberships, err := as.orgs.MembershipsByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, m := range memberships {
+		switch err := as.orgs.Remove(m.OrganizationID, user.ID); err {
+		case nil, ErrNotFound:
+		case ErrLastOwner:
+			return ErrSoleOwner
+		default:
+			return err
+		}
+	}
+	var galleries []Gallery
+	personal := "user_id = ? AND organization_id = 0"
+	if err := as.db.Unscoped().Where(personal, user.ID).Find(&galleries).Error; err != nil {
+		return err
+	}
+	for _, g := range galleries {
+		if err := as.is.DeleteAll(g.ID); err != nil {
+			return err
+		}
+	}
+	if err := as.is.DeleteAvatars(user.ID); err != nil {
+		return err
+	}
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where(personal, user.ID).Delete(&Gallery{}).Error; err != nil {
+			return err
+		}
+		for _, row := range userOwnedRows() {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(row).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("inviter_id = ?", user.ID).Delete(&Invitation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("key = ?", emailKey(user.Email)).Delete(&loginAttempt{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&User{Model: gorm.Model{ID: user.ID}}).Error
+	})
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+/*adminPageSize is the most users a search in the admin console returns*/
+const adminPageSize = 50
+
+/*Suspended reports whether an admin locked the user out*/
+func (u *User) Suspended() bool {
+	return u.SuspendedAt != nil
+}
+
+func (us *userService) Suspend(user *User) error {
+	if user.Suspended() {
+		return nil
+	}
+	now := time.Now()
+	user.SuspendedAt = &now
+	return us.Update(user)
+}
+
+func (us *userService) Unsuspend(user *User) error {
+	if !user.Suspended() {
+		return nil
+	}
+	user.SuspendedAt = nil
+	return us.Update(user)
+}
+
+/*ForcePasswordReset clears the password, so it stops working right away, and returns a
+reset token for the user. Whatever else gets into the account is revoked as well, the user
+has to come back through the reset link. Unlike InitiateReset it is not rate limited*/
+func (us *userService) ForcePasswordReset(user *User) (string, error) {
+	user.PasswordHash = ""
+	if err := us.Update(user); err != nil {
+		return "", err
+	}
+	if err := us.revokeAccess(user.ID, 0); err != nil {
+		return "", err
+	}
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return "", err
+	}
+	return pwr.Token, nil
+}
+
+/*UserSummary is a user as listed in the admin console*/
+type UserSummary struct {
+	User
+	Galleries int
+	/*StorageBytes counts the image files of all galleries*/
+	StorageBytes int64
+}
+
+/*Storage is StorageBytes for people*/
+func (s *UserSummary) Storage() string {
+	return formatBytes(s.StorageBytes)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+/*AdminService answers the questions of the admin console*/
+type AdminService interface {
+	/*SearchUsers finds users whose name, email or username contain the query, newest
+	first. An empty query lists the newest users*/
+	SearchUsers(query string) ([]UserSummary, error)
+	/*Summarize counts the galleries and storage of a single user*/
+	Summarize(user *User) (*UserSummary, error)
+}
+
+func NewAdminService(db *gorm.DB, is ImageService) AdminService {
+	return &adminService{
+		db: db,
+		is: is,
+	}
+}
+
+var _ AdminService = &adminService{}
+
+type adminService struct {
+	db *gorm.DB
+	is ImageService
+}
+
+/*likeEscaper keeps the query from being read as a LIKE pattern*/
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (as *adminService) SearchUsers(query string) ([]UserSummary, error) {
+	db := as.db.Order("id desc").Limit(adminPageSize)
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := "%" + likeEscaper.Replace(query) + "%"
+		db = db.Where("name ILIKE ? OR email ILIKE ? OR username ILIKE ?", pattern, pattern, pattern)
+	}
+	var users []User
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	var galleries []Gallery
+	if err := as.db.Select("id", "user_id").Where("user_id IN ?", ids).Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+	byUser := make(map[uint][]Gallery)
+	for _, g := range galleries {
+		byUser[g.UserID] = append(byUser[g.UserID], g)
+	}
+	summaries := make([]UserSummary, len(users))
+	for i, u := range users {
+		summaries[i] = UserSummary{User: u}
+		if err := as.count(&summaries[i], byUser[u.ID]); err != nil {
+			return nil, err
+		}
+	}
+	return summaries, nil
+}
+
+func (as *adminService) Summarize(user *User) (*UserSummary, error) {
+	var galleries []Gallery
+	if err := as.db.Select("id", "user_id").Where("user_id = ?", user.ID).Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+	summary := UserSummary{User: *user}
+	if err := as.count(&summary, galleries); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func (as *adminService) count(summary *UserSummary, galleries []Gallery) error {
+	summary.Galleries = len(galleries)
+	for _, g := range galleries {
+		n, err := as.is.Usage(g.ID)
+		if err != nil {
+			return err
+		}
+		summary.StorageBytes += n
+	}
+	return nil
+}
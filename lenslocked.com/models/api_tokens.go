@@ -0,0 +1,325 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	/*APITokenPrefix starts every API token so that leaked tokens are easy to recognise*/
+	APITokenPrefix = "ll_"
+	/*apiTokenTouchInterval limits how often LastUsedAt is written*/
+	apiTokenTouchInterval = time.Minute
+)
+
+/*Scopes an API token can be granted. Tokens only ever work on the galleries of their owner*/
+const (
+	ScopeGalleriesRead  = "galleries:read"
+	ScopeGalleriesWrite = "galleries:write"
+	ScopeImagesWrite    = "images:write"
+)
+
+/*APIScopes lists every scope in the order they are shown on the settings page*/
+var APIScopes = []string{ScopeGalleriesRead, ScopeGalleriesWrite, ScopeImagesWrite}
+
+/*APIToken lets scripts act as a user through an "Authorization: Bearer" header. Like
+sessions only the HMAC of the token is stored, the token itself is shown once after creation*/
+type APIToken struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	Name   string `gorm:"not null"`
+	/*Scopes is a space separated list of the granted scopes*/
+	Scopes    string `gorm:"not null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;uniqueIndex"`
+	/*ExpiresAt is nil for tokens that don't expire*/
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+/*APITokenDB is used to interact with the api_tokens table*/
+type APITokenDB interface {
+	ByID(id uint) (*APIToken, error)
+	ByToken(token string) (*APIToken, error)
+	ByUserID(userID uint) ([]APIToken, error)
+
+	Create(token *APIToken) error
+	Update(token *APIToken) error
+	Delete(id uint) error
+	/*DeleteByUserID revokes every token of the user*/
+	DeleteByUserID(userID uint) error
+	/*Rehash replaces the token hash after the HMAC key was rotated*/
+	Rehash(id uint, tokenHash string) error
+}
+
+/*APITokenService manages the API tokens of users*/
+type APITokenService interface {
+	/*Authenticate returns the active token for the value of an Authorization header and records that it was used*/
+	Authenticate(token string) (*APIToken, error)
+	/*Revoke deletes a token of the user*/
+	Revoke(user *User, id uint) error
+	APITokenDB
+}
+
+func NewAPITokenService(db *gorm.DB, keys *hash.Keyring) APITokenService {
+	return &apiTokenService{
+		APITokenDB: newAPITokenValidator(&apiTokenGorm{db}, keys),
+	}
+}
+
+var _ APITokenService = &apiTokenService{}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+func (ats *apiTokenService) Authenticate(token string) (*APIToken, error) {
+	t, err := ats.ByToken(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if t.Expired() {
+		return nil, ErrInvalidToken
+	}
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > apiTokenTouchInterval {
+		now := time.Now()
+		t.LastUsedAt = &now
+		if err := ats.Update(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (ats *apiTokenService) Revoke(user *User, id uint) error {
+	t, err := ats.ByID(id)
+	if err != nil {
+		return err
+	}
+	if t.UserID != user.ID {
+		return ErrNotFound
+	}
+	return ats.Delete(t.ID)
+}
+
+type apiTokenValFunc func(*APIToken) error
+
+func runAPITokenValFuncs(t *APIToken, fns ...apiTokenValFunc) error {
+	for _, fn := range fns {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ APITokenDB = &apiTokenValidator{}
+
+func newAPITokenValidator(db APITokenDB, hmac *hash.Keyring) *apiTokenValidator {
+	return &apiTokenValidator{
+		APITokenDB: db,
+		hmac:       hmac,
+	}
+}
+
+type apiTokenValidator struct {
+	APITokenDB
+	hmac *hash.Keyring
+}
+
+func (atv *apiTokenValidator) ByToken(token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	var t *APIToken
+	stale, err := findHashed(atv.hmac, token, func(tokenHash string) (err error) {
+		t, err = atv.APITokenDB.ByToken(tokenHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		t.TokenHash = atv.hmac.Hash(token)
+		atv.APITokenDB.Rehash(t.ID, t.TokenHash)
+	}
+	return t, nil
+}
+
+func (atv *apiTokenValidator) Create(t *APIToken) error {
+	err := runAPITokenValFuncs(t,
+		atv.requireUserID,
+		atv.requireName,
+		atv.normalizeScopes,
+		atv.expiresInFuture,
+		atv.setToken,
+		atv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Create(t)
+}
+
+func (atv *apiTokenValidator) Update(t *APIToken) error {
+	err := runAPITokenValFuncs(t,
+		atv.requireUserID,
+		atv.requireName,
+		atv.normalizeScopes,
+	)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Update(t)
+}
+
+func (atv *apiTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return atv.APITokenDB.Delete(id)
+}
+
+func (atv *apiTokenValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return atv.APITokenDB.DeleteByUserID(userID)
+}
+
+func (atv *apiTokenValidator) requireUserID(t *APIToken) error {
+	if t.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) requireName(t *APIToken) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return ErrAPITokenNameRequired
+	}
+	return nil
+}
+
+/*normalizeScopes drops duplicates and rejects unknown scopes*/
+func (atv *apiTokenValidator) normalizeScopes(t *APIToken) error {
+	granted := make(map[string]bool)
+	for _, s := range t.ScopeList() {
+		granted[s] = true
+	}
+	var scopes []string
+	for _, s := range APIScopes {
+		if granted[s] {
+			scopes = append(scopes, s)
+			delete(granted, s)
+		}
+	}
+	if len(granted) > 0 {
+		return ErrAPITokenScopeInvalid
+	}
+	if len(scopes) == 0 {
+		return ErrAPITokenScopeRequired
+	}
+	t.Scopes = strings.Join(scopes, " ")
+	return nil
+}
+
+func (atv *apiTokenValidator) expiresInFuture(t *APIToken) error {
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return ErrAPITokenExpiry
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) setToken(t *APIToken) error {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	t.Token = APITokenPrefix + token
+	return nil
+}
+
+func (atv *apiTokenValidator) hmacToken(t *APIToken) error {
+	if t.Token == "" {
+		return nil
+	}
+	t.TokenHash = atv.hmac.Hash(t.Token)
+	return nil
+}
+
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+func (atg *apiTokenGorm) ByID(id uint) (*APIToken, error) {
+	var t APIToken
+	err := first(atg.db.Where("id = ?", id), &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (atg *apiTokenGorm) ByToken(tokenHash string) (*APIToken, error) {
+	var t APIToken
+	err := first(atg.db.Where("token_hash = ?", tokenHash), &t)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &t, nil
+}
+
+func (atg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := atg.db.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (atg *apiTokenGorm) Create(t *APIToken) error {
+	return atg.db.Create(t).Error
+}
+
+func (atg *apiTokenGorm) Update(t *APIToken) error {
+	return atg.db.Save(t).Error
+}
+
+func (atg *apiTokenGorm) Rehash(id uint, tokenHash string) error {
+	return rehash(atg.db, &APIToken{}, id, "token_hash", tokenHash)
+}
+
+/*Delete removes the row for good, a revoked token has no reason to stay around*/
+func (atg *apiTokenGorm) Delete(id uint) error {
+	t := APIToken{Model: gorm.Model{ID: id}}
+	return atg.db.Unscoped().Delete(&t).Error
+}
+
+func (atg *apiTokenGorm) DeleteByUserID(userID uint) error {
+	return atg.db.Unscoped().Where("user_id = ?", userID).Delete(&APIToken{}).Error
+}
@@ -0,0 +1,126 @@
+package models
+
+import "gorm.io/gorm"
+
+/*The actions recorded in the audit log*/
+const (
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationStop  = "impersonation.stop"
+	AuditUserSuspend        = "user.suspend"
+	AuditUserUnsuspend      = "user.unsuspend"
+	AuditUserResetPassword  = "user.reset_password"
+	AuditGalleryDelete      = "gallery.delete"
+)
+
+/*AuditEvent records an action staff took on behalf of or against a user. Events are kept
+when either user is purged, so that the log stays complete*/
+type AuditEvent struct {
+	gorm.Model
+	/*ActorID is the staff member who acted*/
+	ActorID uint `gorm:"not null;index"`
+	/*SubjectID is the user the action was about*/
+	SubjectID uint   `gorm:"not null;index"`
+	Action    string `gorm:"not null"`
+	/*Detail says more about the action, like the title of a deleted gallery*/
+	Detail string
+	IP     string
+}
+
+/*AuditDB is used to interact with the audit_events table. Events are never updated or deleted*/
+type AuditDB interface {
+	/*BySubjectID returns the newest events about the user first*/
+	BySubjectID(subjectID uint) ([]AuditEvent, error)
+	Create(event *AuditEvent) error
+}
+
+/*AuditService is the audit log of staff actions*/
+type AuditService interface {
+	Record(actorID, subjectID uint, action, detail, ip string) error
+	AuditDB
+}
+
+func NewAuditService(db *gorm.DB) AuditService {
+	return &auditService{
+		AuditDB: &auditValidator{&auditGorm{db}},
+	}
+}
+
+var _ AuditService = &auditService{}
+
+type auditService struct {
+	AuditDB
+}
+
+func (as *auditService) Record(actorID, subjectID uint, action, detail, ip string) error {
+	return as.Create(&AuditEvent{
+		ActorID:   actorID,
+		SubjectID: subjectID,
+		Action:    action,
+		Detail:    detail,
+		IP:        ip,
+	})
+}
+
+type auditValFunc func(*AuditEvent) error
+
+func runAuditValFuncs(event *AuditEvent, fns ...auditValFunc) error {
+	for _, fn := range fns {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ AuditDB = &auditValidator{}
+
+type auditValidator struct {
+	AuditDB
+}
+
+func (av *auditValidator) Create(event *AuditEvent) error {
+	err := runAuditValFuncs(event,
+		av.requireUserIDs,
+		av.requireAction,
+	)
+	if err != nil {
+		return err
+	}
+	return av.AuditDB.Create(event)
+}
+
+func (av *auditValidator) requireUserIDs(event *AuditEvent) error {
+	if event.ActorID <= 0 || event.SubjectID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (av *auditValidator) requireAction(event *AuditEvent) error {
+	if event.Action == "" {
+		return ErrAuditActionRequired
+	}
+	return nil
+}
+
+var _ AuditDB = &auditGorm{}
+
+type auditGorm struct {
+	db *gorm.DB
+}
+
+/*auditPageSize is how many events BySubjectID returns*/
+const auditPageSize = 100
+
+func (ag *auditGorm) BySubjectID(subjectID uint) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := ag.db.Where("subject_id = ?", subjectID).Order("id desc").Limit(auditPageSize).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (ag *auditGorm) Create(event *AuditEvent) error {
+	return ag.db.Create(event).Error
+}
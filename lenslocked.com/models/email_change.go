@@ -0,0 +1,98 @@
+package models
+
+import "time"
+
+const (
+	/*emailChangeTTL is how long the link sent to the new address can be used*/
+	emailChangeTTL = 24 * time.Hour
+	/*emailRevertTTL gives the owner of the old address time to notice a change they didn't make*/
+	emailRevertTTL = 7 * 24 * time.Hour
+)
+
+/*EmailChange holds the tokens for both addresses involved in a change*/
+type EmailChange struct {
+	OldEmail     string
+	NewEmail     string
+	ConfirmToken string
+	RevertToken  string
+}
+
+func (us *userService) InitiateEmailChange(user *User, pw, code, newEmail string) (*EmailChange, error) {
+	if err := us.checkCredentials(user, pw, code); err != nil {
+		return nil, err
+	}
+	candidate := User{Email: newEmail}
+	candidate.ID = user.ID
+	if err := us.validator.checkNewEmail(&candidate); err != nil {
+		return nil, err
+	}
+	if candidate.Email == user.Email {
+		return nil, ErrEmailUnchanged
+	}
+
+	/*only the latest request can be confirmed*/
+	if err := us.userTokenDB.DeleteByUserID(user.ID, tokenChangeEmail); err != nil {
+		return nil, err
+	}
+	confirm := userToken{
+		UserID:    user.ID,
+		Purpose:   tokenChangeEmail,
+		Email:     candidate.Email,
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+	}
+	if err := us.userTokenDB.Create(&confirm); err != nil {
+		return nil, err
+	}
+	revert := userToken{
+		UserID:    user.ID,
+		Purpose:   tokenRevertEmail,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(emailRevertTTL),
+	}
+	if err := us.userTokenDB.Create(&revert); err != nil {
+		return nil, err
+	}
+	return &EmailChange{
+		OldEmail:     user.Email,
+		NewEmail:     candidate.Email,
+		ConfirmToken: confirm.Token,
+		RevertToken:  revert.Token,
+	}, nil
+}
+
+/*CompleteEmailChange switches to the new address. Following the link proved the user
+can read mail sent there, so the address counts as verified. If the address was taken
+in the meantime ErrEmailIsRegistered is returned and nothing changes*/
+func (us *userService) CompleteEmailChange(token string) (*User, error) {
+	ut, err := us.consumeToken(tokenChangeEmail, token)
+	if err != nil {
+		return nil, err
+	}
+	return us.switchEmail(ut)
+}
+
+/*RevertEmailChange undoes a change, or cancels it if it wasn't confirmed yet*/
+func (us *userService) RevertEmailChange(token string) (*User, error) {
+	ut, err := us.consumeToken(tokenRevertEmail, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.userTokenDB.DeleteByUserID(ut.UserID, tokenChangeEmail); err != nil {
+		return nil, err
+	}
+	return us.switchEmail(ut)
+}
+
+func (us *userService) switchEmail(ut *userToken) (*User, error) {
+	user, err := us.ByID(ut.UserID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user.Email = ut.Email
+	user.EmailVerifiedAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -9,17 +9,77 @@ const (
 	ErrInvalidEmailOrPassword modelError = "models: Invalid email or password. Please try again"
 	ErrInvalidToken           modelError = "models: The token is invalid or empty"
 
-	ErrEmailRequired     modelError = "models: Email address is required"
-	ErrEmailInvalid      modelError = "models: Email address is invalid"
-	ErrEmailIsRegistered modelError = "models: Email is already registered"
+	ErrEmailRequired        modelError = "models: Email address is required"
+	ErrEmailInvalid         modelError = "models: Email address is invalid"
+	ErrEmailIsRegistered    modelError = "models: Email is already registered"
+	ErrEmailAlreadyVerified modelError = "models: Email address has already been verified"
+	ErrEmailNotVerified     modelError = "models: Please verify your email address first"
+	ErrEmailUnchanged       modelError = "models: That is already your email address"
 
-	ErrPasswordIsShort    modelError = "models: Password must be at least eight characters long"
 	ErrPasswordIsRequired modelError = "models: Password is required"
+	/*the password policy errors are returned wrapped in a PasswordError, which fills in the limits*/
+	ErrPasswordIsShort         modelError = "models: Password must be at least %d characters long"
+	ErrPasswordIsLong          modelError = "models: Password must be at most %d characters long"
+	ErrPasswordHasPersonalInfo modelError = "models: Password must not contain your name or email address"
+	ErrPasswordIsCommon        modelError = "models: This password is too common, please pick another one"
 
-	ErrTitleRequired modelError = "models: Title is required"
+	ErrUsernameInvalid  modelError = "models: Usernames have 3 to 30 letters, digits, dashes or underscores"
+	ErrUsernameReserved modelError = "models: This username is reserved, please pick another one"
+	ErrUsernameTaken    modelError = "models: This username is already taken"
+	ErrBioTooLong       modelError = "models: Your bio must be at most 500 characters long"
+	ErrTooManyLinks     modelError = "models: You can add up to 5 links"
+	ErrLinkInvalid      modelError = "models: Links must be full http or https addresses"
+	ErrAvatarInvalid    modelError = "models: Avatars must be JPEG, PNG, GIF or WebP images of at most 2 MB"
+
+	ErrTitleRequired     modelError = "models: Title is required"
+	ErrVisibilityInvalid modelError = "models: Please pick private, unlisted or public"
 
 	ErrTokenInvalid modelError = "models: token provided is not valid"
 
+	ErrTooManyRequests modelError = "models: Too many requests, please try again later"
+	/*ErrTooManyAttempts doesn't say whether the account exists, the check for your email hint is shown either way*/
+	ErrTooManyAttempts modelError = "models: Too many failed sign in attempts. Please wait a moment and try again. " +
+		"If the account is locked we have sent you an email to unlock it"
+
+	ErrIdentityEmailTaken modelError = "models: An account with this email address already exists. " +
+		"Please sign in with your password and connect the account from your account page"
+	ErrIdentityLinked    modelError = "models: This account is already connected to another user"
+	ErrIdentityLastLogin modelError = "models: Please set a password before disconnecting your only sign in method"
+	ErrIdentityNotYours  modelError = "models: Please confirm with an account that is connected to yours"
+	ErrIdentityStale     modelError = "models: The provider did not ask you to sign in again, please try once more"
+
+	ErrAPITokenNameRequired  modelError = "models: Please give the token a name"
+	ErrAPITokenScopeRequired modelError = "models: Please pick at least one scope"
+	ErrAPITokenScopeInvalid  modelError = "models: Unknown scope"
+	ErrAPITokenExpiry        modelError = "models: The expiry date has to be in the future"
+
+	ErrRegistrationClosed modelError = "models: Sign ups are closed at the moment"
+	ErrInvitationRequired modelError = "models: You need an invitation to sign up"
+	ErrInvitationInvalid  modelError = "models: This invitation is invalid, expired or used up"
+	ErrInvitationUses     modelError = "models: An invitation can be used between 1 and 100 times"
+	ErrInvitationExpiry   modelError = "models: The invitation has to expire in the future"
+
+	ErrDeletionPending modelError = "models: Your account is already scheduled for deletion"
+
+	ErrAccountSuspended     modelError = "models: This account has been suspended. Please contact support"
+	ErrAdminSelf            modelError = "models: You can't do this to your own account"
+	ErrImpersonateAdmin     modelError = "models: Admins can't be signed in as"
+	ErrImpersonateSuspended modelError = "models: Suspended users can't be signed in as"
+
+	ErrOrgNameRequired      modelError = "models: Please give the organization a name"
+	ErrOrgRoleInvalid       modelError = "models: Please pick the owner, editor or viewer role"
+	ErrOrgPermission        modelError = "models: You need to be an editor of the organization to do this"
+	ErrLastOwner            modelError = "models: An organization needs at least one owner"
+	ErrSoleOwner            modelError = "models: Your organizations need another owner before your account can be deleted"
+	ErrAlreadyMember        modelError = "models: You are already a member of this organization"
+	ErrOrgInvitationInvalid modelError = "models: The invitation is invalid or has expired"
+	ErrOrgInvitationEmail   modelError = "models: The invitation was sent to another email address"
+
+	ErrTOTPCodeInvalid    modelError = "models: The verification code is not valid"
+	ErrTOTPCodeRequired   modelError = "models: Please enter a code from your authenticator app"
+	ErrTOTPAlreadyEnabled modelError = "models: Two-factor authentication is already enabled"
+	ErrTOTPNotEnrolled    modelError = "models: Two-factor authentication has not been set up"
+
 	/*ErrInvalidID is returned when invalid ID is provided to a method like Delete*/
 	ErrInvalidID privateError = "models: ID provided was invalid"
 
@@ -27,6 +87,13 @@ const (
 	ErrRememberHashIsRequired privateError = "models: Remember hash is required"
 
 	ErrUserIDRequired privateError = "models: User ID is required "
+
+	ErrTokenPurposeRequired privateError = "models: Token purpose is required"
+	ErrTokenExpiryRequired  privateError = "models: Token expiry is required"
+	ErrIdentityInvalid      privateError = "models: Identity provider and subject are required"
+	ErrRoleInvalid          privateError = "models: Role is invalid"
+	ErrAuditActionRequired  privateError = "models: Audit action is required"
+	ErrLoginMethodRequired  privateError = "models: Sign in method is required"
 )
 
 type modelError string
@@ -1,14 +1,79 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"time"
+)
 
+/*Visibility says who gets to see a gallery besides the people who manage it*/
+type Visibility string
+
+const (
+	/*VisibilityPrivate galleries are only shown to the people who manage them*/
+	VisibilityPrivate Visibility = "private"
+	/*VisibilityUnlisted galleries are shown to anyone with their link*/
+	VisibilityUnlisted Visibility = "unlisted"
+	/*VisibilityPublic galleries are shown to everyone and listed on the profile of their creator*/
+	VisibilityPublic Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	/*imageTokenTTL is how long the image links on a shared gallery page work*/
+	imageTokenTTL = time.Hour
+	/*imageTokenPurpose keeps other signed tokens from being accepted for images*/
+	imageTokenPurpose = "gallery-images"
+)
+
+/*Gallery belongs to the user who created it, unless OrganizationID is set. Galleries of
+an organization belong to its members and UserID only tells who created them*/
 type Gallery struct {
 	gorm.Model
-	UserID uint    `gorm:"not null;index"`
-	Title  string  `gorm:"not null"`
+	UserID         uint       `gorm:"not null;index"`
+	OrganizationID uint       `gorm:"not null;default:0;index"`
+	Title          string     `gorm:"not null"`
+	Visibility     Visibility `gorm:"not null;default:private"`
+	/*Slug is the unguessable part of the link an unlisted gallery is shared with*/
+	Slug   string  `gorm:"index:idx_galleries_slug,unique,where:slug <> ''"`
 	Images []Image `gorm:"-"`
 }
 
+/*LinkShared reports whether anyone with the link may see the gallery*/
+func (g *Gallery) LinkShared() bool {
+	return g.Visibility == VisibilityUnlisted || g.Visibility == VisibilityPublic
+}
+
+func (g *Gallery) Public() bool {
+	return g.Visibility == VisibilityPublic
+}
+
+/*ShareURL is the link to share the gallery with, empty for private galleries*/
+func (g *Gallery) ShareURL() string {
+	if !g.LinkShared() || g.Slug == "" {
+		return ""
+	}
+	return "/g/" + g.Slug
+}
+
+/*Cover is the image shown for the gallery in lists, nil for galleries without images*/
+func (g *Gallery) Cover() *Image {
+	if len(g.Images) == 0 {
+		return nil
+	}
+	return &g.Images[0]
+}
+
 func (g *Gallery) ImagesSplitN(n int) [][]Image {
 	ret := make([][]Image, n)
 	for i := 0; i < n; i++ {
@@ -22,21 +87,67 @@ func (g *Gallery) ImagesSplitN(n int) [][]Image {
 }
 
 type GalleryService interface {
+	/*SignImages adds a short-lived token to the paths of the images, so that visitors who
+	were shown the gallery by its link can load them*/
+	SignImages(gallery *Gallery) error
+	/*ImageTokenValid reports whether the token was issued by SignImages for the gallery
+	and its current link*/
+	ImageTokenValid(gallery *Gallery, token string) bool
 	GalleryDB
 }
 
 type galleryService struct {
 	GalleryDB
+	keys *hash.Keyring
+}
+
+func (gs *galleryService) SignImages(gallery *Gallery) error {
+	token, err := gs.keys.Sign(hash.Claims{
+		Purpose:   imageTokenPurpose,
+		Subject:   imageTokenSubject(gallery),
+		ExpiresAt: time.Now().Add(imageTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+	for i := range gallery.Images {
+		gallery.Images[i].Token = token
+	}
+	return nil
+}
+
+func (gs *galleryService) ImageTokenValid(gallery *Gallery, token string) bool {
+	if token == "" || gallery.Slug == "" {
+		return false
+	}
+	claims, err := gs.keys.Verify(token, imageTokenPurpose)
+	return err == nil && claims.Subject == imageTokenSubject(gallery)
+}
+
+/*imageTokenSubject includes the slug, so that image links die along with the link of
+the gallery*/
+func imageTokenSubject(gallery *Gallery) string {
+	return fmt.Sprintf("%d:%s", gallery.ID, gallery.Slug)
 }
 
 type galleryValidator struct {
 	GalleryDB
 }
 
+func (gv *galleryValidator) BySlug(slug string) (*Gallery, error) {
+	if slug == "" {
+		return nil, ErrNotFound
+	}
+	return gv.GalleryDB.BySlug(slug)
+}
+
 func (gv *galleryValidator) Create(g *Gallery) error {
 	err := runGalleryValFuncs(g,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.setVisibilityIfUnset,
+		gv.visibilityValid,
+		gv.setSlugIfUnset)
 	if err != nil {
 		return err
 	}
@@ -46,7 +157,11 @@ func (gv *galleryValidator) Create(g *Gallery) error {
 func (gv *galleryValidator) Update(g *Gallery) error {
 	err := runGalleryValFuncs(g,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.setVisibilityIfUnset,
+		gv.visibilityValid,
+		gv.newSlugIfVisibilityChanged,
+		gv.setSlugIfUnset)
 	if err != nil {
 		return err
 	}
@@ -75,19 +190,62 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+func (gv *galleryValidator) setVisibilityIfUnset(g *Gallery) error {
+	if g.Visibility == "" {
+		g.Visibility = VisibilityPrivate
+	}
+	return nil
+}
+
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	if !g.Visibility.Valid() {
+		return ErrVisibilityInvalid
+	}
+	return nil
+}
+
+/*newSlugIfVisibilityChanged revokes the link whenever the visibility changes, so that
+making a gallery private and unlisted again doesn't bring back the old link*/
+func (gv *galleryValidator) newSlugIfVisibilityChanged(g *Gallery) error {
+	existing, err := gv.GalleryDB.ByID(g.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Visibility != g.Visibility {
+		g.Slug = ""
+	}
+	return nil
+}
+
+func (gv *galleryValidator) setSlugIfUnset(g *Gallery) error {
+	if g.Slug != "" {
+		return nil
+	}
+	slug, err := rand.Nonce()
+	if err != nil {
+		return err
+	}
+	g.Slug = slug
+	return nil
+}
+
 type GalleryDB interface {
 	ByID(id uint) (*Gallery, error)
+	BySlug(slug string) (*Gallery, error)
+	/*ByUserID returns the galleries the user owns personally*/
 	ByUserID(userID uint) ([]Gallery, error)
+	ByOrganizationID(orgID uint) ([]Gallery, error)
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
 	Delete(id uint) error
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
+func NewGalleryService(db *gorm.DB, keys *hash.Keyring) GalleryService {
 	return &galleryService{
 		GalleryDB: &galleryValidator{
 			&galleryGorm{db},
 		},
+		keys: keys,
 	}
 }
 
@@ -104,9 +262,18 @@ func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
 	return &gallery, err
 }
 
+func (gg *galleryGorm) BySlug(slug string) (*Gallery, error) {
+	var gallery Gallery
+	err := first(gg.db.Where("slug = ?", slug), &gallery)
+	if err != nil {
+		return nil, err
+	}
+	return &gallery, nil
+}
+
 func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
 	var galleries []Gallery
-	err := gg.db.Where("user_id = ?", userID).Find(&galleries).Error
+	err := gg.db.Where("user_id = ? AND organization_id = 0", userID).Find(&galleries).Error
 	if err != nil {
 		return nil, err
 	}
@@ -114,6 +281,15 @@ func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
 	return galleries, nil
 }
 
+func (gg *galleryGorm) ByOrganizationID(orgID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Where("organization_id = ?", orgID).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
 func (gg *galleryGorm) Create(gallery *Gallery) error {
 	return gg.db.Create(gallery).Error
 }
@@ -0,0 +1,284 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+/*reauthMaxAge is how long ago the provider may have checked the credentials of a user who
+confirms who they are with it*/
+const reauthMaxAge = 5 * time.Minute
+
+/*Identity links an account at an external OpenID Connect provider to a user*/
+type Identity struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Provider string `gorm:"not null;uniqueIndex:idx_identities_provider_subject"`
+	Subject  string `gorm:"not null;uniqueIndex:idx_identities_provider_subject"`
+	Email    string
+}
+
+/*ExternalIdentity is what a provider told us about the user who just signed in there*/
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	/*AuthTime is when the provider checked the credentials, zero if it didn't say*/
+	AuthTime time.Time
+}
+
+type IdentityDB interface {
+	ByID(id uint) (*Identity, error)
+	ByProviderSubject(provider, subject string) (*Identity, error)
+	ByUserID(userID uint) ([]Identity, error)
+	Create(identity *Identity) error
+	Delete(id uint) error
+}
+
+/*IdentityService signs users in with external identities and manages the links*/
+type IdentityService interface {
+	/*SignIn returns the user the identity belongs to. An unknown identity is linked to the
+	user with the same verified email address, otherwise a new password-less user is created
+	if the registration mode lets anybody sign up. Suspended users get ErrAccountSuspended*/
+	SignIn(ext ExternalIdentity) (*User, error)
+	/*Link connects the identity to a user who is already signed in*/
+	Link(user *User, ext ExternalIdentity) error
+	/*Unlink removes the identity unless it is the only way left for the user to sign in*/
+	Unlink(user *User, id uint) error
+	/*Reauthenticate checks that the identity belongs to the user and that the provider
+	just checked the credentials, for users who have neither a password nor two-factor
+	authentication to confirm with*/
+	Reauthenticate(user *User, ext ExternalIdentity) error
+	IdentityDB
+}
+
+func NewIdentityService(db *gorm.DB, udb UserDB, invitations InvitationService) IdentityService {
+	return &identityService{
+		IdentityDB:  &identityValidator{&identityGorm{db}},
+		udb:         udb,
+		invitations: invitations,
+	}
+}
+
+var _ IdentityService = &identityService{}
+
+type identityService struct {
+	IdentityDB
+	udb         UserDB
+	invitations InvitationService
+}
+
+func (is *identityService) SignIn(ext ExternalIdentity) (*User, error) {
+	identity, err := is.ByProviderSubject(ext.Provider, ext.Subject)
+	switch err {
+	case nil:
+		user, err := is.udb.ByID(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user.Suspended() {
+			return nil, ErrAccountSuspended
+		}
+		return user, nil
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	if ext.Email == "" {
+		return nil, ErrEmailRequired
+	}
+	user, err := is.udb.ByEmail(ext.Email)
+	switch err {
+	case nil:
+		/*only link to accounts whose owner proved the address on both sides, otherwise
+		whoever registered the address first would get access to the other account*/
+		if !ext.EmailVerified || !user.EmailVerified() {
+			return nil, ErrIdentityEmailTaken
+		}
+		if user.Suspended() {
+			return nil, ErrAccountSuspended
+		}
+		if err := is.Create(newIdentity(user.ID, ext)); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	/*there is no way to hand over an invitation code through the provider*/
+	if err := is.invitations.Check(""); err != nil {
+		return nil, err
+	}
+	user = &User{
+		Name:       ext.Name,
+		Email:      ext.Email,
+		Identities: []Identity{*newIdentity(0, ext)},
+	}
+	if ext.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := is.udb.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (is *identityService) Link(user *User, ext ExternalIdentity) error {
+	identity, err := is.ByProviderSubject(ext.Provider, ext.Subject)
+	switch err {
+	case nil:
+		if identity.UserID != user.ID {
+			return ErrIdentityLinked
+		}
+		return nil
+	case ErrNotFound:
+		return is.Create(newIdentity(user.ID, ext))
+	default:
+		return err
+	}
+}
+
+func (is *identityService) Unlink(user *User, id uint) error {
+	identity, err := is.ByID(id)
+	if err != nil {
+		return err
+	}
+	if identity.UserID != user.ID {
+		return ErrNotFound
+	}
+	if user.PasswordHash == "" {
+		identities, err := is.ByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		if len(identities) <= 1 {
+			return ErrIdentityLastLogin
+		}
+	}
+	return is.Delete(identity.ID)
+}
+
+func (is *identityService) Reauthenticate(user *User, ext ExternalIdentity) error {
+	identity, err := is.ByProviderSubject(ext.Provider, ext.Subject)
+	switch err {
+	case nil:
+		if identity.UserID != user.ID {
+			return ErrIdentityNotYours
+		}
+	case ErrNotFound:
+		return ErrIdentityNotYours
+	default:
+		return err
+	}
+	if ext.AuthTime.IsZero() || time.Since(ext.AuthTime) > reauthMaxAge {
+		return ErrIdentityStale
+	}
+	return nil
+}
+
+func newIdentity(userID uint, ext ExternalIdentity) *Identity {
+	return &Identity{
+		UserID:   userID,
+		Provider: ext.Provider,
+		Subject:  ext.Subject,
+		Email:    strings.ToLower(strings.TrimSpace(ext.Email)),
+	}
+}
+
+type identityValidator struct {
+	IdentityDB
+}
+
+func (iv *identityValidator) Create(identity *Identity) error {
+	err := runIdentityValFns(identity,
+		iv.requireUserID,
+		iv.requireProviderSubject,
+	)
+	if err != nil {
+		return err
+	}
+	return iv.IdentityDB.Create(identity)
+}
+
+func (iv *identityValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return iv.IdentityDB.Delete(id)
+}
+
+func (iv *identityValidator) requireUserID(identity *Identity) error {
+	if identity.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (iv *identityValidator) requireProviderSubject(identity *Identity) error {
+	if identity.Provider == "" || identity.Subject == "" {
+		return ErrIdentityInvalid
+	}
+	return nil
+}
+
+type identityValFn func(*Identity) error
+
+func runIdentityValFns(identity *Identity, fns ...identityValFn) error {
+	for _, fn := range fns {
+		if err := fn(identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ IdentityDB = &identityGorm{}
+
+type identityGorm struct {
+	db *gorm.DB
+}
+
+func (ig *identityGorm) ByID(id uint) (*Identity, error) {
+	var identity Identity
+	err := first(ig.db.Where("id = ?", id), &identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (ig *identityGorm) ByProviderSubject(provider, subject string) (*Identity, error) {
+	var identity Identity
+	err := first(ig.db.Where("provider = ? AND subject = ?", provider, subject), &identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (ig *identityGorm) ByUserID(userID uint) ([]Identity, error) {
+	var identities []Identity
+	err := ig.db.Where("user_id = ?", userID).Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (ig *identityGorm) Create(identity *Identity) error {
+	return ig.db.Create(identity).Error
+}
+
+/*Delete removes the row for good, a soft deleted row would keep the unique index
+from linking the same external account again*/
+func (ig *identityGorm) Delete(id uint) error {
+	identity := Identity{Model: gorm.Model{ID: id}}
+	return ig.db.Unscoped().Delete(&identity).Error
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+/*fakeIdentityDB knows a single identity of the owner*/
+type fakeIdentityDB struct {
+	IdentityDB
+}
+
+func (db *fakeIdentityDB) ByProviderSubject(provider, subject string) (*Identity, error) {
+	if provider != "mock" || subject != "owner" {
+		return nil, ErrNotFound
+	}
+	return &Identity{Model: gorm.Model{ID: 1}, UserID: ownerID, Provider: provider, Subject: subject}, nil
+}
+
+/*TestReauthenticateWithProvider covers users without a password or two-factor authentication,
+who confirm who they are by signing in with their provider again*/
+func TestReauthenticateWithProvider(t *testing.T) {
+	is := &identityService{IdentityDB: &fakeIdentityDB{}}
+	owner := testUser(ownerID)
+	tests := []struct {
+		name string
+		user *User
+		ext  ExternalIdentity
+		want error
+	}{
+		{"fresh sign in", owner, ExternalIdentity{Provider: "mock", Subject: "owner", AuthTime: time.Now()}, nil},
+		{"no auth_time", owner, ExternalIdentity{Provider: "mock", Subject: "owner"}, ErrIdentityStale},
+		{"old sign in", owner, ExternalIdentity{Provider: "mock", Subject: "owner",
+			AuthTime: time.Now().Add(-time.Hour)}, ErrIdentityStale},
+		{"another user's identity", testUser(strangerID), ExternalIdentity{Provider: "mock", Subject: "owner",
+			AuthTime: time.Now()}, ErrIdentityNotYours},
+		{"unknown identity", owner, ExternalIdentity{Provider: "mock", Subject: "someone",
+			AuthTime: time.Now()}, ErrIdentityNotYours},
+	}
+	for _, tt := range tests {
+		if err := is.Reauthenticate(tt.user, tt.ext); err != tt.want {
+			t.Errorf("%s: Expected %v, Received %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -1,24 +1,43 @@
 package models
 
 import (
+	"bufio"
 	"fmt"
+	"github.com/username/project-name/rand"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+/*maxAvatarSize is the largest avatar upload in bytes*/
+const maxAvatarSize = 2 << 20
+
+/*avatarExts maps the image types accepted as avatars to their file extension*/
+var avatarExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
 // Image is not stored in database
 type Image struct {
 	GalleryID uint
 	Filename  string
+	/*Token is added to the path of images shown by the link of a gallery, see SignImages*/
+	Token string
 }
 
 func (i *Image) Path() string {
 	temp := url.URL{
 		Path: "/" + i.RelativePath(),
 	}
+	if i.Token != "" {
+		temp.RawQuery = url.Values{"t": {i.Token}}.Encode()
+	}
 	return temp.String()
 }
 
@@ -26,10 +45,36 @@ func (i *Image) RelativePath() string {
 	return fmt.Sprintf("images/galleries/%v/%v", i.GalleryID, i.Filename)
 }
 
+/*Avatar is the profile picture of a user, like Image it is not stored in database*/
+type Avatar struct {
+	UserID   uint
+	Filename string
+}
+
+func (a *Avatar) Path() string {
+	temp := url.URL{
+		Path: "/" + a.RelativePath(),
+	}
+	return temp.String()
+}
+
+func (a *Avatar) RelativePath() string {
+	return fmt.Sprintf("images/avatars/%v/%v", a.UserID, a.Filename)
+}
+
 type ImageService interface {
 	Create(galleryID uint, r io.ReadCloser, filename string) error
 	ByGalleryID(galleryID uint) ([]Image, error)
 	Delete(i *Image) error
+	/*DeleteAll removes the directory with all images of the gallery*/
+	DeleteAll(galleryID uint) error
+	/*Usage is the size in bytes of all images of the gallery*/
+	Usage(galleryID uint) (int64, error)
+
+	/*CreateAvatar replaces the avatar of the user and returns the new filename. Every
+	avatar gets a new random name so that browsers don't show a cached old one*/
+	CreateAvatar(userID uint, r io.Reader) (string, error)
+	DeleteAvatars(userID uint) error
 }
 
 func NewImageService() ImageService {
@@ -83,6 +128,65 @@ func (is *imageService) Delete(i *Image) error {
 	return os.Remove(i.RelativePath())
 }
 
+func (is *imageService) DeleteAll(galleryID uint) error {
+	return os.RemoveAll(is.imagePath(galleryID))
+}
+
+func (is *imageService) Usage(galleryID uint) (int64, error) {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, img := range images {
+		info, err := os.Stat(img.RelativePath())
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+/*CreateAvatar looks at the content rather than the uploaded filename to tell whether it is an image*/
+func (is *imageService) CreateAvatar(userID uint, r io.Reader) (string, error) {
+	br := bufio.NewReader(io.LimitReader(r, maxAvatarSize+1))
+	head, _ := br.Peek(512)
+	ext, ok := avatarExts[http.DetectContentType(head)]
+	if !ok {
+		return "", ErrAvatarInvalid
+	}
+	name, err := rand.String(12)
+	if err != nil {
+		return "", err
+	}
+	avatar := Avatar{UserID: userID, Filename: name + ext}
+	if err := is.DeleteAvatars(userID); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(avatar.RelativePath()), 0755); err != nil {
+		return "", err
+	}
+	dst, err := os.Create(avatar.RelativePath())
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	n, err := io.Copy(dst, br)
+	if err == nil && n > maxAvatarSize {
+		err = ErrAvatarInvalid
+	}
+	if err != nil {
+		os.Remove(avatar.RelativePath())
+		return "", err
+	}
+	return avatar.Filename, nil
+}
+
+func (is *imageService) DeleteAvatars(userID uint) error {
+	return os.RemoveAll(fmt.Sprintf("images/avatars/%v/", userID))
+}
+
 func (is *imageService) mkImagePath(galleryID uint) (string, error) {
 	galleryPath := is.imagePath(galleryID)
 	err := os.MkdirAll(galleryPath, 0755)
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+/*RegistrationMode decides who may create an account*/
+type RegistrationMode string
+
+const (
+	/*RegistrationOpen lets anybody sign up, it is used when no mode is configured*/
+	RegistrationOpen RegistrationMode = "open"
+	/*RegistrationInviteOnly requires an invitation code to sign up*/
+	RegistrationInviteOnly RegistrationMode = "invite-only"
+	/*RegistrationClosed turns sign ups off, invitations included*/
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+/*maxInvitationUses caps multi-use codes so a leaked code can't fill the site with accounts*/
+const maxInvitationUses = 100
+
+func (m RegistrationMode) Valid() bool {
+	switch m {
+	case RegistrationOpen, RegistrationInviteOnly, RegistrationClosed:
+		return true
+	default:
+		return false
+	}
+}
+
+/*Invitation lets up to MaxUses people sign up with its code until ExpiresAt. Only the
+HMAC of the code is stored, the code itself is shown once after the invitation is issued*/
+type Invitation struct {
+	gorm.Model
+	InviterID uint `gorm:"not null;index"`
+	/*Email is the address the invitation was sent to, empty when it was only shared as a link.
+	Anyone with the code may use it*/
+	Email     string
+	Code      string    `gorm:"-"`
+	CodeHash  string    `gorm:"not null;uniqueIndex"`
+	MaxUses   int       `gorm:"not null"`
+	Uses      int       `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (inv *Invitation) Expired() bool {
+	return time.Now().After(inv.ExpiresAt)
+}
+
+/*UsesLeft is how many more accounts the invitation can create*/
+func (inv *Invitation) UsesLeft() int {
+	if inv.Expired() || inv.Uses >= inv.MaxUses {
+		return 0
+	}
+	return inv.MaxUses - inv.Uses
+}
+
+/*InvitationDB is used to interact with the invitations table*/
+type InvitationDB interface {
+	ByID(id uint) (*Invitation, error)
+	ByCode(code string) (*Invitation, error)
+	ByInviterID(inviterID uint) ([]Invitation, error)
+
+	Create(inv *Invitation) error
+	Delete(id uint) error
+	/*AddUse counts a sign up, it returns ErrInvitationInvalid when the invitation is
+	expired or used up by then*/
+	AddUse(id uint) error
+	RemoveUse(id uint) error
+	/*Rehash replaces the code hash after the HMAC key was rotated*/
+	Rehash(id uint, codeHash string) error
+}
+
+/*InvitationService issues invitations and decides who may sign up*/
+type InvitationService interface {
+	/*Mode is the configured registration mode*/
+	Mode() RegistrationMode
+	/*Check tells whether a sign up with the code would be admitted, without using it up*/
+	Check(code string) error
+	/*Admit uses up the code when the mode asks for one. The returned invitation is nil
+	when no invitation was needed*/
+	Admit(code string) (*Invitation, error)
+	/*Release gives back the use taken by Admit, for sign ups that failed after all*/
+	Release(inv *Invitation) error
+	/*Revoke deletes an invitation of the user. Admins may revoke any invitation*/
+	Revoke(user *User, id uint) error
+	InvitationDB
+}
+
+func NewInvitationService(db *gorm.DB, keys *hash.Keyring, mode RegistrationMode) InvitationService {
+	if mode == "" {
+		mode = RegistrationOpen
+	}
+	return &invitationService{
+		InvitationDB: newInvitationValidator(&invitationGorm{db}, keys),
+		mode:         mode,
+	}
+}
+
+var _ InvitationService = &invitationService{}
+
+type invitationService struct {
+	InvitationDB
+	mode RegistrationMode
+}
+
+func (is *invitationService) Mode() RegistrationMode {
+	return is.mode
+}
+
+func (is *invitationService) Check(code string) error {
+	_, err := is.invitation(code)
+	return err
+}
+
+func (is *invitationService) Admit(code string) (*Invitation, error) {
+	inv, err := is.invitation(code)
+	if err != nil || inv == nil {
+		return nil, err
+	}
+	if err := is.AddUse(inv.ID); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (is *invitationService) Release(inv *Invitation) error {
+	if inv == nil {
+		return nil
+	}
+	return is.RemoveUse(inv.ID)
+}
+
+/*invitation returns the invitation a sign up needs in the current mode*/
+func (is *invitationService) invitation(code string) (*Invitation, error) {
+	switch is.mode {
+	case RegistrationOpen:
+		return nil, nil
+	case RegistrationInviteOnly:
+	default:
+		return nil, ErrRegistrationClosed
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrInvitationRequired
+	}
+	inv, err := is.ByCode(code)
+	if err != nil {
+		return nil, ErrInvitationInvalid
+	}
+	if inv.UsesLeft() == 0 {
+		return nil, ErrInvitationInvalid
+	}
+	return inv, nil
+}
+
+func (is *invitationService) Revoke(user *User, id uint) error {
+	inv, err := is.ByID(id)
+	if err != nil {
+		return err
+	}
+	if inv.InviterID != user.ID && !user.HasRole(RoleAdmin) {
+		return ErrNotFound
+	}
+	return is.Delete(inv.ID)
+}
+
+type invitationValFunc func(*Invitation) error
+
+func runInvitationValFuncs(inv *Invitation, fns ...invitationValFunc) error {
+	for _, fn := range fns {
+		if err := fn(inv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ InvitationDB = &invitationValidator{}
+
+func newInvitationValidator(db InvitationDB, hmac *hash.Keyring) *invitationValidator {
+	return &invitationValidator{
+		InvitationDB: db,
+		hmac:         hmac,
+	}
+}
+
+type invitationValidator struct {
+	InvitationDB
+	hmac *hash.Keyring
+}
+
+func (iv *invitationValidator) ByCode(code string) (*Invitation, error) {
+	var inv *Invitation
+	stale, err := findHashed(iv.hmac, code, func(codeHash string) (err error) {
+		inv, err = iv.InvitationDB.ByCode(codeHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		inv.CodeHash = iv.hmac.Hash(code)
+		iv.InvitationDB.Rehash(inv.ID, inv.CodeHash)
+	}
+	return inv, nil
+}
+
+func (iv *invitationValidator) Create(inv *Invitation) error {
+	err := runInvitationValFuncs(inv,
+		iv.requireInviterID,
+		iv.normalizeEmail,
+		iv.usesInRange,
+		iv.expiresInFuture,
+		iv.setCode,
+		iv.hmacCode,
+	)
+	if err != nil {
+		return err
+	}
+	return iv.InvitationDB.Create(inv)
+}
+
+func (iv *invitationValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return iv.InvitationDB.Delete(id)
+}
+
+func (iv *invitationValidator) requireInviterID(inv *Invitation) error {
+	if inv.InviterID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (iv *invitationValidator) normalizeEmail(inv *Invitation) error {
+	inv.Email = strings.ToLower(strings.TrimSpace(inv.Email))
+	if inv.Email != "" && !emailRegex.MatchString(inv.Email) {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+func (iv *invitationValidator) usesInRange(inv *Invitation) error {
+	if inv.MaxUses < 1 || inv.MaxUses > maxInvitationUses {
+		return ErrInvitationUses
+	}
+	return nil
+}
+
+func (iv *invitationValidator) expiresInFuture(inv *Invitation) error {
+	if !inv.ExpiresAt.After(time.Now()) {
+		return ErrInvitationExpiry
+	}
+	return nil
+}
+
+func (iv *invitationValidator) setCode(inv *Invitation) error {
+	code, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	inv.Code = code
+	return nil
+}
+
+func (iv *invitationValidator) hmacCode(inv *Invitation) error {
+	if inv.Code == "" {
+		return nil
+	}
+	inv.CodeHash = iv.hmac.Hash(inv.Code)
+	return nil
+}
+
+var _ InvitationDB = &invitationGorm{}
+
+type invitationGorm struct {
+	db *gorm.DB
+}
+
+func (ig *invitationGorm) ByID(id uint) (*Invitation, error) {
+	var inv Invitation
+	err := first(ig.db.Where("id = ?", id), &inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (ig *invitationGorm) ByCode(codeHash string) (*Invitation, error) {
+	var inv Invitation
+	err := first(ig.db.Where("code_hash = ?", codeHash), &inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (ig *invitationGorm) ByInviterID(inviterID uint) ([]Invitation, error) {
+	var invs []Invitation
+	err := ig.db.Where("inviter_id = ?", inviterID).Order("created_at desc").Find(&invs).Error
+	if err != nil {
+		return nil, err
+	}
+	return invs, nil
+}
+
+func (ig *invitationGorm) Create(inv *Invitation) error {
+	return ig.db.Create(inv).Error
+}
+
+func (ig *invitationGorm) Delete(id uint) error {
+	inv := Invitation{Model: gorm.Model{ID: id}}
+	return ig.db.Unscoped().Delete(&inv).Error
+}
+
+func (ig *invitationGorm) Rehash(id uint, codeHash string) error {
+	return rehash(ig.db, &Invitation{}, id, "code_hash", codeHash)
+}
+
+/*AddUse checks and counts in a single statement, two sign ups racing for the last use
+of a code can't both get it*/
+func (ig *invitationGorm) AddUse(id uint) error {
+	res := ig.db.Model(&Invitation{}).
+		Where("id = ? AND uses < max_uses AND expires_at > ?", id, time.Now()).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvitationInvalid
+	}
+	return nil
+}
+
+func (ig *invitationGorm) RemoveUse(id uint) error {
+	return ig.db.Model(&Invitation{}).
+		Where("id = ? AND uses > 0", id).
+		UpdateColumn("uses", gorm.Expr("uses - 1")).Error
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"gorm.io/gorm"
+)
+
+/*findHashed calls find with the hash of token under each key of the ring, the active key
+first, until a row is found. find reports a missing row as ErrNotFound or ErrInvalidToken.
+stale is set when a retired key matched, the stored hash should then be replaced so that
+the key can be dropped from the ring at some point*/
+func findHashed(ring *hash.Keyring, token string, find func(tokenHash string) error) (stale bool, err error) {
+	for i, tokenHash := range ring.Hashes(token) {
+		err = find(tokenHash)
+		if err != ErrNotFound && err != ErrInvalidToken {
+			return err == nil && i > 0, err
+		}
+	}
+	return false, err
+}
+
+/*rehash stores the hash made with the active key for the row with the id*/
+func rehash(db *gorm.DB, model interface{}, id uint, column, hash string) error {
+	return db.Model(model).Where("id = ?", id).UpdateColumn(column, hash).Error
+}
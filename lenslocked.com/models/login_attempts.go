@@ -0,0 +1,296 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	/*attemptWindow is how long a failed attempt is remembered. Counters that haven't
+	changed for that long start from zero again*/
+	attemptWindow = time.Hour
+
+	/*unlockTTL is how long the link in the unlock email stays valid*/
+	unlockTTL = 24 * time.Hour
+)
+
+/*throttlePolicy describes how hard repeated failures are punished*/
+type throttlePolicy struct {
+	/*free is the number of failures that don't slow anybody down*/
+	free int
+	/*base is the delay after the first failure beyond free. It doubles with every further failure up to max*/
+	base time.Duration
+	max  time.Duration
+	/*lockAfter failures the key is refused for lockFor. Zero disables locking*/
+	lockAfter int
+	lockFor   time.Duration
+}
+
+var (
+	accountPolicy = throttlePolicy{
+		free:      3,
+		base:      time.Second,
+		max:       5 * time.Minute,
+		lockAfter: 10,
+		lockFor:   attemptWindow,
+	}
+	/*many users can share an address behind a NAT, so IPs get more room and are never locked*/
+	ipPolicy = throttlePolicy{
+		free: 20,
+		base: time.Second,
+		max:  15 * time.Minute,
+	}
+)
+
+/*Attempts is the number of recent failed sign in attempts for a key*/
+type Attempts struct {
+	Count        int
+	LastFailedAt time.Time
+}
+
+func (a Attempts) locked(p throttlePolicy) bool {
+	return p.lockAfter > 0 && a.Count >= p.lockAfter
+}
+
+/*retryAt is the earliest time another attempt is accepted*/
+func (a Attempts) retryAt(p throttlePolicy) time.Time {
+	if a.locked(p) {
+		return a.LastFailedAt.Add(p.lockFor)
+	}
+	if a.Count < p.free {
+		return time.Time{}
+	}
+	delay := p.base << uint(a.Count-p.free)
+	if delay > p.max || delay <= 0 {
+		delay = p.max
+	}
+	return a.LastFailedAt.Add(delay)
+}
+
+/*AttemptStore counts failed sign in attempts. Keys look like "email:jon@example.com" or "ip:10.0.0.1"*/
+type AttemptStore interface {
+	/*Get returns the attempts within the attemptWindow*/
+	Get(key string) (Attempts, error)
+	/*Fail records a failure and returns the updated attempts*/
+	Fail(key string) (Attempts, error)
+	Reset(key string) error
+}
+
+/*LoginThrottle slows down and eventually locks out repeated failed sign ins
+for an account as well as for a client IP*/
+type LoginThrottle struct {
+	store AttemptStore
+}
+
+func NewLoginThrottle(store AttemptStore) *LoginThrottle {
+	return &LoginThrottle{store: store}
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+/*Check returns ErrTooManyAttempts if the account or the IP has to wait. The answer is
+the same whether or not an account exists for the email*/
+func (lt *LoginThrottle) Check(email, ip string) error {
+	now := time.Now()
+	a, err := lt.store.Get(emailKey(email))
+	if err != nil {
+		return err
+	}
+	if now.Before(a.retryAt(accountPolicy)) {
+		return ErrTooManyAttempts
+	}
+	if ip == "" {
+		return nil
+	}
+	a, err = lt.store.Get(ipKey(ip))
+	if err != nil {
+		return err
+	}
+	if now.Before(a.retryAt(ipPolicy)) {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+/*Fail records a failed attempt. It reports whether the account is locked now*/
+func (lt *LoginThrottle) Fail(email, ip string) (bool, error) {
+	if ip != "" {
+		if _, err := lt.store.Fail(ipKey(ip)); err != nil {
+			return false, err
+		}
+	}
+	a, err := lt.store.Fail(emailKey(email))
+	if err != nil {
+		return false, err
+	}
+	return a.locked(accountPolicy), nil
+}
+
+/*Locked reports whether the account is locked out*/
+func (lt *LoginThrottle) Locked(email string) (bool, error) {
+	a, err := lt.store.Get(emailKey(email))
+	if err != nil {
+		return false, err
+	}
+	return a.locked(accountPolicy) && time.Now().Before(a.retryAt(accountPolicy)), nil
+}
+
+/*Reset forgets the failures of the account, e.g. after a successful sign in or an unlock*/
+func (lt *LoginThrottle) Reset(email string) error {
+	return lt.store.Reset(emailKey(email))
+}
+
+/*InitiateUnlock issues the token for the unlock email. It returns ErrNotFound unless the
+account exists and is locked, and ErrTooManyRequests if an unlock email went out recently*/
+func (us *userService) InitiateUnlock(email string) (string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	locked, err := us.throttle.Locked(user.Email)
+	if err != nil {
+		return "", err
+	}
+	if !locked {
+		return "", ErrNotFound
+	}
+	n, err := us.userTokenDB.CountSince(user.ID, tokenUnlock, time.Now().Add(-accountPolicy.lockFor))
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		return "", ErrTooManyRequests
+	}
+	ut := userToken{
+		UserID:    user.ID,
+		Purpose:   tokenUnlock,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(unlockTTL),
+	}
+	if err := us.userTokenDB.Create(&ut); err != nil {
+		return "", err
+	}
+	return ut.Token, nil
+}
+
+/*CompleteUnlock consumes an unlock token and forgets the failed attempts of the account*/
+func (us *userService) CompleteUnlock(token string) error {
+	ut, err := us.consumeToken(tokenUnlock, token)
+	if err != nil {
+		return err
+	}
+	return us.throttle.Reset(ut.Email)
+}
+
+/*NewMemoryAttemptStore keeps the counters in memory. It is meant for a single
+instance and for tests, counters are lost on restart*/
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{
+		attempts: make(map[string]Attempts),
+	}
+}
+
+/*memoryPruneSize is the number of keys after which stale entries are removed*/
+const memoryPruneSize = 10000
+
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func (ms *memoryAttemptStore) Get(key string) (Attempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	a := ms.attempts[key]
+	if time.Since(a.LastFailedAt) > attemptWindow {
+		return Attempts{}, nil
+	}
+	return a, nil
+}
+
+func (ms *memoryAttemptStore) Fail(key string) (Attempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	a := ms.attempts[key]
+	if now.Sub(a.LastFailedAt) > attemptWindow {
+		a = Attempts{}
+	}
+	a.Count++
+	a.LastFailedAt = now
+	ms.attempts[key] = a
+	if len(ms.attempts) > memoryPruneSize {
+		ms.prune(now)
+	}
+	return a, nil
+}
+
+func (ms *memoryAttemptStore) Reset(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.attempts, key)
+	return nil
+}
+
+func (ms *memoryAttemptStore) prune(now time.Time) {
+	for k, a := range ms.attempts {
+		if now.Sub(a.LastFailedAt) > attemptWindow {
+			delete(ms.attempts, k)
+		}
+	}
+}
+
+/*loginAttempt is a row of the Postgres attempt store*/
+type loginAttempt struct {
+	Key          string    `gorm:"primaryKey"`
+	Count        int       `gorm:"not null"`
+	LastFailedAt time.Time `gorm:"not null"`
+}
+
+/*NewAttemptGorm keeps the counters in the database so that they are shared between instances*/
+func NewAttemptGorm(db *gorm.DB) AttemptStore {
+	return &attemptGorm{db}
+}
+
+type attemptGorm struct {
+	db *gorm.DB
+}
+
+func (ag *attemptGorm) Get(key string) (Attempts, error) {
+	var la loginAttempt
+	err := first(ag.db.Where("key = ? AND last_failed_at > ?", key, time.Now().Add(-attemptWindow)), &la)
+	if err == ErrNotFound {
+		return Attempts{}, nil
+	}
+	if err != nil {
+		return Attempts{}, err
+	}
+	return Attempts{Count: la.Count, LastFailedAt: la.LastFailedAt}, nil
+}
+
+/*Fail increments the counter in a single statement so concurrent failures are all counted*/
+func (ag *attemptGorm) Fail(key string) (Attempts, error) {
+	now := time.Now()
+	var la loginAttempt
+	err := ag.db.Raw(`INSERT INTO login_attempts (key, count, last_failed_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.count + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING key, count, last_failed_at`, key, now, now.Add(-attemptWindow)).Scan(&la).Error
+	if err != nil {
+		return Attempts{}, err
+	}
+	return Attempts{Count: la.Count, LastFailedAt: la.LastFailedAt}, nil
+}
+
+func (ag *attemptGorm) Reset(key string) error {
+	return ag.db.Where("key = ?", key).Delete(&loginAttempt{}).Error
+}
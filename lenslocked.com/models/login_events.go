@@ -0,0 +1,205 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"gorm.io/gorm"
+	"time"
+)
+
+/*The ways a user can sign in, recorded with every login event*/
+const (
+	LoginPassword  = "password"
+	LoginLink      = "link"
+	LoginOAuth     = "oauth"
+	LoginTwoFactor = "two_factor"
+	LoginReset     = "reset"
+)
+
+var loginMethodNames = map[string]string{
+	LoginPassword:  "Password",
+	LoginLink:      "Sign-in link",
+	LoginOAuth:     "Single sign-on",
+	LoginTwoFactor: "Two-factor code",
+	LoginReset:     "Password reset",
+}
+
+/*loginHistorySize is how many events ByUserID returns*/
+const loginHistorySize = 50
+
+/*LoginEvent records a single attempt to sign in to an account. Failure is the reason a
+failed attempt was rejected, it is empty for successful ones*/
+type LoginEvent struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Method    string `gorm:"not null"`
+	Success   bool   `gorm:"not null"`
+	Failure   string
+	IP        string
+	UserAgent string
+	/*DeviceID is the value of the device cookie of the browser, only its HMAC is stored*/
+	DeviceID   string `gorm:"-"`
+	DeviceHash string `gorm:"index"`
+}
+
+/*MethodName is how the sign in method is shown to the user*/
+func (e *LoginEvent) MethodName() string {
+	if name, ok := loginMethodNames[e.Method]; ok {
+		return name
+	}
+	return e.Method
+}
+
+/*LoginEventDB is used to interact with the login_events table*/
+type LoginEventDB interface {
+	/*ByUserID returns the newest events of the user first*/
+	ByUserID(userID uint) ([]LoginEvent, error)
+	Create(event *LoginEvent) error
+	/*KnownDevice reports whether the user signed in successfully with any of the device hashes*/
+	KnownDevice(userID uint, deviceHashes []string) (bool, error)
+	/*HasSignedIn reports whether the user ever signed in successfully*/
+	HasSignedIn(userID uint) (bool, error)
+	/*Purge removes the events created before the provided time*/
+	Purge(before time.Time) error
+}
+
+/*LoginEventService is the sign in history of the users*/
+type LoginEventService interface {
+	/*Record stores the event and reports whether it is a successful sign in from a device
+	the user never signed in with before. The very first sign in of a user doesn't count,
+	there is nothing to compare it to*/
+	Record(event *LoginEvent) (newDevice bool, err error)
+	LoginEventDB
+}
+
+func NewLoginEventService(db *gorm.DB, keys *hash.Keyring) LoginEventService {
+	return &loginEventService{
+		LoginEventDB: &loginEventValidator{
+			LoginEventDB: &loginEventGorm{db},
+			hmac:         keys,
+		},
+		hmac: keys,
+	}
+}
+
+var _ LoginEventService = &loginEventService{}
+
+type loginEventService struct {
+	LoginEventDB
+	hmac *hash.Keyring
+}
+
+func (ls *loginEventService) Record(event *LoginEvent) (bool, error) {
+	newDevice := false
+	if event.Success && event.DeviceID != "" {
+		signedIn, err := ls.HasSignedIn(event.UserID)
+		if err != nil {
+			return false, err
+		}
+		/*the device was hashed with a retired key if it was last seen before a rotation*/
+		known, err := ls.KnownDevice(event.UserID, ls.hmac.Hashes(event.DeviceID))
+		if err != nil {
+			return false, err
+		}
+		newDevice = signedIn && !known
+	}
+	if err := ls.Create(event); err != nil {
+		return false, err
+	}
+	return newDevice, nil
+}
+
+type loginEventValFunc func(*LoginEvent) error
+
+func runLoginEventValFuncs(event *LoginEvent, fns ...loginEventValFunc) error {
+	for _, fn := range fns {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ LoginEventDB = &loginEventValidator{}
+
+type loginEventValidator struct {
+	LoginEventDB
+	hmac *hash.Keyring
+}
+
+func (lv *loginEventValidator) Create(event *LoginEvent) error {
+	err := runLoginEventValFuncs(event,
+		lv.requireUserID,
+		lv.requireMethod,
+		lv.hmacDevice,
+	)
+	if err != nil {
+		return err
+	}
+	return lv.LoginEventDB.Create(event)
+}
+
+func (lv *loginEventValidator) ByUserID(userID uint) ([]LoginEvent, error) {
+	if userID <= 0 {
+		return nil, ErrUserIDRequired
+	}
+	return lv.LoginEventDB.ByUserID(userID)
+}
+
+func (lv *loginEventValidator) requireUserID(event *LoginEvent) error {
+	if event.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (lv *loginEventValidator) requireMethod(event *LoginEvent) error {
+	if event.Method == "" {
+		return ErrLoginMethodRequired
+	}
+	return nil
+}
+
+func (lv *loginEventValidator) hmacDevice(event *LoginEvent) error {
+	if event.DeviceID == "" {
+		return nil
+	}
+	event.DeviceHash = lv.hmac.Hash(event.DeviceID)
+	return nil
+}
+
+var _ LoginEventDB = &loginEventGorm{}
+
+type loginEventGorm struct {
+	db *gorm.DB
+}
+
+func (lg *loginEventGorm) ByUserID(userID uint) ([]LoginEvent, error) {
+	var events []LoginEvent
+	err := lg.db.Where("user_id = ?", userID).Order("id desc").Limit(loginHistorySize).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (lg *loginEventGorm) Create(event *LoginEvent) error {
+	return lg.db.Create(event).Error
+}
+
+func (lg *loginEventGorm) KnownDevice(userID uint, deviceHashes []string) (bool, error) {
+	var n int64
+	err := lg.db.Model(&LoginEvent{}).
+		Where("user_id = ? AND success AND device_hash IN ?", userID, deviceHashes).
+		Count(&n).Error
+	return n > 0, err
+}
+
+func (lg *loginEventGorm) HasSignedIn(userID uint) (bool, error) {
+	var n int64
+	err := lg.db.Model(&LoginEvent{}).Where("user_id = ? AND success", userID).Count(&n).Error
+	return n > 0, err
+}
+
+func (lg *loginEventGorm) Purge(before time.Time) error {
+	return lg.db.Unscoped().Where("created_at < ?", before).Delete(&LoginEvent{}).Error
+}
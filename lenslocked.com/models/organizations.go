@@ -0,0 +1,498 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+/*OrgRole is what a member may do with the galleries of an organization. Each role includes
+everything the roles below it may do*/
+type OrgRole string
+
+const (
+	/*OrgViewer can look at the galleries of the organization*/
+	OrgViewer OrgRole = "viewer"
+	/*OrgEditor can also create galleries and change them*/
+	OrgEditor OrgRole = "editor"
+	/*OrgOwner can also delete galleries and manage the members*/
+	OrgOwner OrgRole = "owner"
+)
+
+var orgRoleRank = map[OrgRole]int{
+	OrgViewer: 1,
+	OrgEditor: 2,
+	OrgOwner:  3,
+}
+
+func (r OrgRole) Valid() bool {
+	return orgRoleRank[r] > 0
+}
+
+/*AtLeast reports whether the role is role or one above it*/
+func (r OrgRole) AtLeast(role OrgRole) bool {
+	return r.Valid() && orgRoleRank[r] >= orgRoleRank[role]
+}
+
+/*orgInvitationTTL is how long an invitation to join an organization can be accepted*/
+const orgInvitationTTL = 7 * 24 * time.Hour
+
+/*Organization is a studio or team whose members share the galleries it owns*/
+type Organization struct {
+	gorm.Model
+	Name string `gorm:"not null"`
+}
+
+/*Membership makes a user part of an organization. Organization and User are only filled
+in when listing memberships*/
+type Membership struct {
+	gorm.Model
+	OrganizationID uint          `gorm:"not null;uniqueIndex:idx_membership"`
+	UserID         uint          `gorm:"not null;uniqueIndex:idx_membership;index"`
+	Role           OrgRole       `gorm:"not null"`
+	Organization   *Organization `gorm:"-"`
+	User           *User         `gorm:"-"`
+}
+
+/*OrgInvitation lets whoever signs in with Email join the organization once, until it
+expires after orgInvitationTTL. Like userToken only the HMAC of the token is stored.
+Organization is only filled in when the invitation is looked up by its token*/
+type OrgInvitation struct {
+	gorm.Model
+	OrganizationID uint          `gorm:"not null;index"`
+	Organization   *Organization `gorm:"-"`
+	Email          string        `gorm:"not null"`
+	Role           OrgRole       `gorm:"not null"`
+	Token          string        `gorm:"-"`
+	TokenHash      string        `gorm:"not null;uniqueIndex"`
+	ExpiresAt      time.Time     `gorm:"not null"`
+}
+
+func (inv *OrgInvitation) Expired() bool {
+	return time.Now().After(inv.ExpiresAt)
+}
+
+/*OrganizationDB is used to interact with the organizations and memberships tables*/
+type OrganizationDB interface {
+	ByID(id uint) (*Organization, error)
+	/*Create adds the organization along with its first owner*/
+	Create(org *Organization, ownerID uint) error
+
+	Membership(orgID, userID uint) (*Membership, error)
+	/*MembershipsByUserID returns the memberships of the user with their organization*/
+	MembershipsByUserID(userID uint) ([]Membership, error)
+	/*MembershipsByOrganizationID returns the memberships of the organization, owners first*/
+	MembershipsByOrganizationID(orgID uint) ([]Membership, error)
+	AddMember(m *Membership) error
+	/*RemoveMember returns ErrLastOwner instead of removing the only owner*/
+	RemoveMember(orgID, userID uint) error
+	CountOwners(orgID uint) (int64, error)
+
+	InvitationByToken(token string) (*OrgInvitation, error)
+	CreateInvitation(inv *OrgInvitation) error
+	/*AcceptInvitation deletes the invitation and adds the membership together. It returns
+	ErrNotFound if the invitation was already gone, so whoever deletes it first is the only
+	one allowed to use it*/
+	AcceptInvitation(invID uint, m *Membership) error
+	/*DeleteInvitations removes the pending invitations of the email address*/
+	DeleteInvitations(orgID uint, email string) error
+	/*PurgeInvitations removes expired invitations for good*/
+	PurgeInvitations() error
+}
+
+/*OrganizationService manages organizations and who belongs to them*/
+type OrganizationService interface {
+	/*Members returns the memberships of the organization with their user*/
+	Members(orgID uint) ([]Membership, error)
+	/*Invite returns a token that lets whoever signs in with the email address join the
+	organization with the role*/
+	Invite(org *Organization, email string, role OrgRole) (*OrgInvitation, error)
+	/*Invitation reads an invitation token without accepting it*/
+	Invitation(token string) (*OrgInvitation, error)
+	/*Accept makes the user a member and uses the invitation up, it has to be for the
+	user's email address*/
+	Accept(user *User, token string) (*Organization, error)
+	/*Remove takes the user out of the organization along with the invitations still sent
+	to them, the last owner can't leave*/
+	Remove(orgID, userID uint) error
+	OrganizationDB
+}
+
+func NewOrganizationService(db *gorm.DB, keys *hash.Keyring, udb UserDB) OrganizationService {
+	return &organizationService{
+		OrganizationDB: &organizationValidator{&organizationGorm{db}, keys},
+		udb:            udb,
+	}
+}
+
+var _ OrganizationService = &organizationService{}
+
+type organizationService struct {
+	OrganizationDB
+	udb UserDB
+}
+
+func (orgs *organizationService) Members(orgID uint) ([]Membership, error) {
+	members, err := orgs.MembershipsByOrganizationID(orgID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range members {
+		user, err := orgs.udb.ByID(members[i].UserID)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		members[i].User = user
+	}
+	return members, nil
+}
+
+func (orgs *organizationService) Invite(org *Organization, email string, role OrgRole) (*OrgInvitation, error) {
+	inv := OrgInvitation{
+		OrganizationID: org.ID,
+		Email:          email,
+		Role:           role,
+		ExpiresAt:      time.Now().Add(orgInvitationTTL),
+	}
+	if err := orgs.CreateInvitation(&inv); err != nil {
+		return nil, err
+	}
+	inv.Organization = org
+	return &inv, nil
+}
+
+/*Invitation reports every problem with the token as ErrOrgInvitationInvalid*/
+func (orgs *organizationService) Invitation(token string) (*OrgInvitation, error) {
+	inv, err := orgs.InvitationByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrOrgInvitationInvalid
+		}
+		return nil, err
+	}
+	if inv.Expired() {
+		return nil, ErrOrgInvitationInvalid
+	}
+	inv.Organization, err = orgs.ByID(inv.OrganizationID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrOrgInvitationInvalid
+		}
+		return nil, err
+	}
+	inv.Token = token
+	return inv, nil
+}
+
+/*Accept leaves the role of existing members alone, an invitation can't be used to
+change it*/
+func (orgs *organizationService) Accept(user *User, token string) (*Organization, error) {
+	inv, err := orgs.Invitation(token)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != inv.Email {
+		return nil, ErrOrgInvitationEmail
+	}
+	_, err = orgs.Membership(inv.OrganizationID, user.ID)
+	switch err {
+	case nil:
+		return nil, ErrAlreadyMember
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+	err = orgs.AcceptInvitation(inv.ID, &Membership{
+		OrganizationID: inv.OrganizationID,
+		UserID:         user.ID,
+		Role:           inv.Role,
+	})
+	switch err {
+	case nil:
+	case ErrNotFound:
+		return nil, ErrOrgInvitationInvalid
+	default:
+		return nil, err
+	}
+	return inv.Organization, nil
+}
+
+func (orgs *organizationService) Remove(orgID, userID uint) error {
+	if err := orgs.RemoveMember(orgID, userID); err != nil {
+		return err
+	}
+	user, err := orgs.udb.ByID(userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return orgs.DeleteInvitations(orgID, user.Email)
+}
+
+type organizationValFunc func(*Organization) error
+
+func runOrganizationValFuncs(org *Organization, fns ...organizationValFunc) error {
+	for _, fn := range fns {
+		if err := fn(org); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ OrganizationDB = &organizationValidator{}
+
+type organizationValidator struct {
+	OrganizationDB
+	hmac *hash.Keyring
+}
+
+func (ov *organizationValidator) Create(org *Organization, ownerID uint) error {
+	if ownerID <= 0 {
+		return ErrUserIDRequired
+	}
+	if err := runOrganizationValFuncs(org, ov.nameRequired); err != nil {
+		return err
+	}
+	return ov.OrganizationDB.Create(org, ownerID)
+}
+
+func (ov *organizationValidator) AddMember(m *Membership) error {
+	if err := ov.checkMember(m); err != nil {
+		return err
+	}
+	return ov.OrganizationDB.AddMember(m)
+}
+
+func (ov *organizationValidator) AcceptInvitation(invID uint, m *Membership) error {
+	if invID <= 0 {
+		return ErrInvalidID
+	}
+	if err := ov.checkMember(m); err != nil {
+		return err
+	}
+	return ov.OrganizationDB.AcceptInvitation(invID, m)
+}
+
+func (ov *organizationValidator) checkMember(m *Membership) error {
+	if m.OrganizationID <= 0 {
+		return ErrInvalidID
+	}
+	if m.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	if !m.Role.Valid() {
+		return ErrOrgRoleInvalid
+	}
+	return nil
+}
+
+/*InvitationByToken accepts tokens hashed with a retired key as well. Invitations are
+single-use, so they are not hashed again*/
+func (ov *organizationValidator) InvitationByToken(token string) (*OrgInvitation, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	var inv *OrgInvitation
+	_, err := findHashed(ov.hmac, token, func(tokenHash string) (err error) {
+		inv, err = ov.OrganizationDB.InvitationByToken(tokenHash)
+		return err
+	})
+	return inv, err
+}
+
+func (ov *organizationValidator) CreateInvitation(inv *OrgInvitation) error {
+	if inv.OrganizationID <= 0 {
+		return ErrInvalidID
+	}
+	inv.Email = strings.ToLower(strings.TrimSpace(inv.Email))
+	if inv.Email == "" {
+		return ErrEmailRequired
+	}
+	if !emailRegex.MatchString(inv.Email) {
+		return ErrEmailInvalid
+	}
+	if !inv.Role.Valid() {
+		return ErrOrgRoleInvalid
+	}
+	if inv.ExpiresAt.IsZero() {
+		return ErrTokenExpiryRequired
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	inv.Token = token
+	inv.TokenHash = ov.hmac.Hash(token)
+	return ov.OrganizationDB.CreateInvitation(inv)
+}
+
+func (ov *organizationValidator) DeleteInvitations(orgID uint, email string) error {
+	return ov.OrganizationDB.DeleteInvitations(orgID, strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (ov *organizationValidator) nameRequired(org *Organization) error {
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" {
+		return ErrOrgNameRequired
+	}
+	return nil
+}
+
+var _ OrganizationDB = &organizationGorm{}
+
+type organizationGorm struct {
+	db *gorm.DB
+}
+
+func (og *organizationGorm) ByID(id uint) (*Organization, error) {
+	var org Organization
+	err := first(og.db.Where("id = ?", id), &org)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (og *organizationGorm) Create(org *Organization, ownerID uint) error {
+	return og.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&Membership{
+			OrganizationID: org.ID,
+			UserID:         ownerID,
+			Role:           OrgOwner,
+		}).Error
+	})
+}
+
+func (og *organizationGorm) Membership(orgID, userID uint) (*Membership, error) {
+	var m Membership
+	err := first(og.db.Where("organization_id = ? AND user_id = ?", orgID, userID), &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (og *organizationGorm) MembershipsByUserID(userID uint) ([]Membership, error) {
+	var memberships []Membership
+	if err := og.db.Where("user_id = ?", userID).Order("organization_id").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(memberships))
+	for i, m := range memberships {
+		ids[i] = m.OrganizationID
+	}
+	var orgs []Organization
+	if err := og.db.Where("id IN ?", ids).Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*Organization, len(orgs))
+	for i := range orgs {
+		byID[orgs[i].ID] = &orgs[i]
+	}
+	ret := make([]Membership, 0, len(memberships))
+	for _, m := range memberships {
+		if org, ok := byID[m.OrganizationID]; ok {
+			m.Organization = org
+			ret = append(ret, m)
+		}
+	}
+	return ret, nil
+}
+
+func (og *organizationGorm) MembershipsByOrganizationID(orgID uint) ([]Membership, error) {
+	var memberships []Membership
+	err := og.db.Where("organization_id = ?", orgID).
+		Order("CASE role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END, id").
+		Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (og *organizationGorm) AddMember(m *Membership) error {
+	return og.db.Create(m).Error
+}
+
+/*RemoveMember deletes the row for good, so that the user can be invited again. The
+memberships of the organization stay locked until then, two owners leaving at the same
+time can't both count the other one as the remaining owner*/
+func (og *organizationGorm) RemoveMember(orgID, userID uint) error {
+	return og.db.Transaction(func(tx *gorm.DB) error {
+		var members []Membership
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("organization_id = ?", orgID).Find(&members).Error
+		if err != nil {
+			return err
+		}
+		var found *Membership
+		owners := 0
+		for i := range members {
+			if members[i].UserID == userID {
+				found = &members[i]
+			}
+			if members[i].Role == OrgOwner {
+				owners++
+			}
+		}
+		if found == nil {
+			return ErrNotFound
+		}
+		if found.Role == OrgOwner && owners <= 1 {
+			return ErrLastOwner
+		}
+		return tx.Unscoped().Delete(found).Error
+	})
+}
+
+func (og *organizationGorm) CountOwners(orgID uint) (int64, error) {
+	var n int64
+	err := og.db.Model(&Membership{}).Where("organization_id = ? AND role = ?", orgID, OrgOwner).Count(&n).Error
+	return n, err
+}
+
+func (og *organizationGorm) InvitationByToken(tokenHash string) (*OrgInvitation, error) {
+	var inv OrgInvitation
+	err := first(og.db.Where("token_hash = ?", tokenHash), &inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (og *organizationGorm) CreateInvitation(inv *OrgInvitation) error {
+	return og.db.Create(inv).Error
+}
+
+/*AcceptInvitation keeps the invitation if the membership can't be added, so it can be
+used again*/
+func (og *organizationGorm) AcceptInvitation(invID uint, m *Membership) error {
+	return og.db.Transaction(func(tx *gorm.DB) error {
+		db := tx.Unscoped().Delete(&OrgInvitation{Model: gorm.Model{ID: invID}})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Create(m).Error
+	})
+}
+
+func (og *organizationGorm) DeleteInvitations(orgID uint, email string) error {
+	return og.db.Unscoped().Where("organization_id = ? AND email = ?", orgID, email).
+		Delete(&OrgInvitation{}).Error
+}
+
+func (og *organizationGorm) PurgeInvitations() error {
+	return og.db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&OrgInvitation{}).Error
+}
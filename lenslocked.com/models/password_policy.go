@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"github.com/username/project-name/password"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	/*defaultPasswordMaxLength keeps hashing long inputs cheap. Note that bcrypt ignores
+	everything after 72 bytes, which 18 four-byte runes already reach*/
+	defaultPasswordMaxLength = 128
+
+	/*personalInfoMinLength is the shortest part of a name or email that is looked for in a password*/
+	personalInfoMinLength = 3
+)
+
+/*PasswordPolicy is checked whenever a user picks a new password. Zero values fall back to the defaults*/
+type PasswordPolicy struct {
+	/*MinLength and MaxLength count runes, not bytes*/
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+	/*AllowPersonalInfo accepts passwords that contain the user's name or email*/
+	AllowPersonalInfo bool `json:"allow_personal_info"`
+	/*AllowCommon accepts passwords from the bundled list of common and breached passwords*/
+	AllowCommon bool `json:"allow_common"`
+}
+
+func (p PasswordPolicy) withDefaults() PasswordPolicy {
+	if p.MinLength <= 0 {
+		p.MinLength = defaultPasswordMinLength
+	}
+	if p.MaxLength <= 0 {
+		p.MaxLength = defaultPasswordMaxLength
+	}
+	return p
+}
+
+/*Check returns a PasswordError for the first rule the password breaks*/
+func (p PasswordPolicy) Check(pw string, user *User) error {
+	p = p.withDefaults()
+	n := utf8.RuneCountInString(pw)
+	if n < p.MinLength {
+		return PasswordError{Err: ErrPasswordIsShort, Limit: p.MinLength}
+	}
+	if n > p.MaxLength {
+		return PasswordError{Err: ErrPasswordIsLong, Limit: p.MaxLength}
+	}
+	if !p.AllowPersonalInfo && containsPersonalInfo(pw, user) {
+		return PasswordError{Err: ErrPasswordHasPersonalInfo}
+	}
+	if !p.AllowCommon && password.IsCommon(pw) {
+		return PasswordError{Err: ErrPasswordIsCommon}
+	}
+	return nil
+}
+
+/*containsPersonalInfo looks for the email, its local part and every word of the name*/
+func containsPersonalInfo(pw string, user *User) bool {
+	if user == nil {
+		return false
+	}
+	pw = strings.ToLower(pw)
+	email := strings.ToLower(strings.TrimSpace(user.Email))
+	parts := strings.Fields(strings.ToLower(user.Name))
+	if email != "" {
+		parts = append(parts, email)
+		if at := strings.Index(email, "@"); at > 0 {
+			parts = append(parts, email[:at])
+		}
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= personalInfoMinLength && strings.Contains(pw, part) {
+			return true
+		}
+	}
+	return false
+}
+
+/*PasswordError tells which rule of the password policy was broken. Err is one of the
+ErrPassword... errors, Limit is filled in for the length rules*/
+type PasswordError struct {
+	Err   modelError
+	Limit int
+}
+
+func (e PasswordError) Error() string {
+	return string(e.message())
+}
+
+func (e PasswordError) Public() string {
+	return e.message().Public()
+}
+
+func (e PasswordError) Unwrap() error {
+	return e.Err
+}
+
+func (e PasswordError) message() modelError {
+	if e.Limit > 0 {
+		return modelError(fmt.Sprintf(string(e.Err), e.Limit))
+	}
+	return e.Err
+}
@@ -0,0 +1,189 @@
+package models
+
+/*Role is what a user is allowed to do beyond their own content. Each role includes
+everything the roles below it may do*/
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+/*HasRole reports whether the user has the role or one above it*/
+func (u *User) HasRole(role Role) bool {
+	if u == nil {
+		return false
+	}
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+/*Permission is an action a user wants to take, optionally on a resource*/
+type Permission string
+
+const (
+	/*PermGalleryCreate and the invitation permissions are checked without a resource*/
+	PermGalleryCreate Permission = "gallery:create"
+	PermGalleryView   Permission = "gallery:view"
+	/*PermGalleryViewLink is viewing a gallery by its shared link instead of its ID*/
+	PermGalleryViewLink Permission = "gallery:view-link"
+	PermGalleryEdit     Permission = "gallery:edit"
+	PermGalleryDelete   Permission = "gallery:delete"
+	PermImageUpload     Permission = "image:upload"
+	PermImageDelete     Permission = "image:delete"
+
+	/*PermOrgView and PermOrgManage target an organization, PermGalleryCreate does as
+	well when the gallery is created for one*/
+	PermOrgView   Permission = "organization:view"
+	PermOrgManage Permission = "organization:manage"
+	/*PermMemberRemove targets a membership, taking the user out of the organization*/
+	PermMemberRemove Permission = "member:remove"
+
+	PermInvitationCreate Permission = "invitation:create"
+	/*PermInvitationMultiUse allows invitations that more than one person can sign up with*/
+	PermInvitationMultiUse Permission = "invitation:multi-use"
+)
+
+/*Policy is the one place that decides who may do what. Controllers and middleware ask
+it instead of comparing user IDs themselves*/
+type Policy struct {
+	udb  UserDB
+	orgs OrganizationDB
+	vp   VerificationPolicy
+}
+
+func NewPolicy(udb UserDB, orgs OrganizationDB, vp VerificationPolicy) *Policy {
+	return &Policy{
+		udb:  udb,
+		orgs: orgs,
+		vp:   vp,
+	}
+}
+
+/*Can reports whether user may take the action on the resource. user is nil for visitors
+who are not signed in, resource is nil for actions that don't target anything*/
+func (p *Policy) Can(user *User, perm Permission, resource interface{}) bool {
+	switch res := resource.(type) {
+	case nil:
+		return p.can(user, perm)
+	case *Gallery:
+		return p.canGallery(user, perm, res)
+	case *Organization:
+		return p.canOrganization(user, perm, res)
+	case *Membership:
+		return p.canMembership(user, perm, res)
+	default:
+		return false
+	}
+}
+
+func (p *Policy) can(user *User, perm Permission) bool {
+	if user == nil {
+		return false
+	}
+	switch perm {
+	case PermGalleryCreate:
+		return p.vp.CanCreateGallery(user)
+	case PermInvitationCreate:
+		return user.EmailVerified() || user.HasRole(RoleAdmin)
+	case PermInvitationMultiUse:
+		return user.HasRole(RoleAdmin)
+	default:
+		return false
+	}
+}
+
+/*canGallery lets owners do anything with their galleries and moderators look at and
+take down any gallery. Members of an organization get what their role allows with its
+galleries. Everybody else only gets to view public galleries, and unlisted ones by their link*/
+func (p *Policy) canGallery(user *User, perm Permission, gallery *Gallery) bool {
+	role := p.galleryRole(user, gallery)
+	switch perm {
+	case PermGalleryView:
+		return role.AtLeast(OrgViewer) || user.HasRole(RoleModerator) || (gallery.Public() && p.shared(gallery))
+	case PermGalleryViewLink:
+		return role.AtLeast(OrgViewer) || user.HasRole(RoleModerator) ||
+			(gallery.LinkShared() && p.shared(gallery))
+	case PermGalleryEdit, PermImageUpload:
+		return role.AtLeast(OrgEditor)
+	case PermImageDelete:
+		return role.AtLeast(OrgEditor) || user.HasRole(RoleModerator)
+	case PermGalleryDelete:
+		return role.AtLeast(OrgOwner) || user.HasRole(RoleModerator)
+	default:
+		return false
+	}
+}
+
+/*galleryRole is the role of the user for the gallery, the creator of a personal gallery
+is its owner*/
+func (p *Policy) galleryRole(user *User, gallery *Gallery) OrgRole {
+	if user == nil {
+		return ""
+	}
+	if gallery.OrganizationID == 0 {
+		if user.ID == gallery.UserID {
+			return OrgOwner
+		}
+		return ""
+	}
+	return p.orgRole(user, gallery.OrganizationID)
+}
+
+/*orgRole is the role of the user in the organization, empty for anyone but its members*/
+func (p *Policy) orgRole(user *User, orgID uint) OrgRole {
+	if user == nil {
+		return ""
+	}
+	m, err := p.orgs.Membership(orgID, user.ID)
+	if err != nil {
+		return ""
+	}
+	return m.Role
+}
+
+func (p *Policy) canOrganization(user *User, perm Permission, org *Organization) bool {
+	role := p.orgRole(user, org.ID)
+	switch perm {
+	case PermOrgView:
+		return role.AtLeast(OrgViewer)
+	case PermOrgManage:
+		return role.AtLeast(OrgOwner)
+	case PermGalleryCreate:
+		return role.AtLeast(OrgEditor) && p.vp.CanCreateGallery(user)
+	default:
+		return false
+	}
+}
+
+/*canMembership lets owners remove anyone from the organization, everybody else can
+only leave it. Whether the last owner may go is up to the OrganizationService*/
+func (p *Policy) canMembership(user *User, perm Permission, m *Membership) bool {
+	role := p.orgRole(user, m.OrganizationID)
+	switch perm {
+	case PermMemberRemove:
+		return role.AtLeast(OrgOwner) || (role.AtLeast(OrgViewer) && m.UserID == user.ID)
+	default:
+		return false
+	}
+}
+
+/*shared applies the verification policy to galleries shown to anyone but their owner.
+Galleries of accounts waiting to be deleted are hidden right away*/
+func (p *Policy) shared(gallery *Gallery) bool {
+	owner, err := p.udb.ByID(gallery.UserID)
+	if err != nil || owner.DeletionPending() {
+		return false
+	}
+	return p.vp.CanShare(owner)
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+const (
+	testOrgID    = 10
+	ownerID      = 1
+	orgOwnerID   = 2
+	orgEditorID  = 3
+	orgViewerID  = 4
+	strangerID   = 5
+	moderatorID  = 6
+	unverifiedID = 7
+	deletingID   = 8
+)
+
+/*fakeUserDB knows the creators of the test galleries*/
+type fakeUserDB struct {
+	UserDB
+}
+
+func (db *fakeUserDB) ByID(id uint) (*User, error) {
+	now := time.Now()
+	user := User{Model: gorm.Model{ID: id}, EmailVerifiedAt: &now}
+	switch id {
+	case unverifiedID:
+		user.EmailVerifiedAt = nil
+	case deletingID:
+		user.DeletionRequestedAt = &now
+	}
+	return &user, nil
+}
+
+/*fakeOrgDB knows the members of the test organization*/
+type fakeOrgDB struct {
+	OrganizationDB
+}
+
+func (db *fakeOrgDB) Membership(orgID, userID uint) (*Membership, error) {
+	roles := map[uint]OrgRole{orgOwnerID: OrgOwner, orgEditorID: OrgEditor, orgViewerID: OrgViewer}
+	role, ok := roles[userID]
+	if orgID != testOrgID || !ok {
+		return nil, ErrNotFound
+	}
+	return &Membership{OrganizationID: orgID, UserID: userID, Role: role}, nil
+}
+
+func testingPolicy(vp VerificationPolicy) *Policy {
+	return NewPolicy(&fakeUserDB{}, &fakeOrgDB{}, vp)
+}
+
+func testUser(id uint) *User {
+	user := User{Model: gorm.Model{ID: id}, Role: RoleUser}
+	if id == moderatorID {
+		user.Role = RoleModerator
+	}
+	return &user
+}
+
+func personalGallery(v Visibility) *Gallery {
+	return &Gallery{Model: gorm.Model{ID: 1}, UserID: ownerID, Visibility: v, Slug: "slug"}
+}
+
+func orgGallery(v Visibility) *Gallery {
+	return &Gallery{Model: gorm.Model{ID: 2}, UserID: orgEditorID, OrganizationID: testOrgID, Visibility: v,
+		Slug: "slug"}
+}
+
+var galleryPerms = []Permission{PermGalleryView, PermGalleryViewLink, PermGalleryEdit, PermImageUpload,
+	PermImageDelete, PermGalleryDelete}
+
+func TestPolicyGalleries(t *testing.T) {
+	var (
+		all      = galleryPerms
+		none     = []Permission{}
+		view     = []Permission{PermGalleryView, PermGalleryViewLink}
+		viewLink = []Permission{PermGalleryViewLink}
+		editor   = []Permission{PermGalleryView, PermGalleryViewLink, PermGalleryEdit, PermImageUpload,
+			PermImageDelete}
+		moderator = []Permission{PermGalleryView, PermGalleryViewLink, PermImageDelete, PermGalleryDelete}
+	)
+	tests := []struct {
+		name    string
+		user    *User
+		gallery *Gallery
+		allowed []Permission
+	}{
+		{"owner private", testUser(ownerID), personalGallery(VisibilityPrivate), all},
+		{"owner unlisted", testUser(ownerID), personalGallery(VisibilityUnlisted), all},
+		{"owner public", testUser(ownerID), personalGallery(VisibilityPublic), all},
+		{"moderator private", testUser(moderatorID), personalGallery(VisibilityPrivate), moderator},
+		{"moderator public", testUser(moderatorID), personalGallery(VisibilityPublic), moderator},
+		{"stranger private", testUser(strangerID), personalGallery(VisibilityPrivate), none},
+		{"stranger unlisted", testUser(strangerID), personalGallery(VisibilityUnlisted), viewLink},
+		{"stranger public", testUser(strangerID), personalGallery(VisibilityPublic), view},
+		{"anonymous private", nil, personalGallery(VisibilityPrivate), none},
+		{"anonymous unlisted", nil, personalGallery(VisibilityUnlisted), viewLink},
+		{"anonymous public", nil, personalGallery(VisibilityPublic), view},
+		{"org member on personal gallery", testUser(orgOwnerID), personalGallery(VisibilityPrivate), none},
+
+		{"org owner private", testUser(orgOwnerID), orgGallery(VisibilityPrivate), all},
+		{"org owner public", testUser(orgOwnerID), orgGallery(VisibilityPublic), all},
+		{"org editor private", testUser(orgEditorID), orgGallery(VisibilityPrivate), editor},
+		{"org editor public", testUser(orgEditorID), orgGallery(VisibilityPublic), editor},
+		{"org viewer private", testUser(orgViewerID), orgGallery(VisibilityPrivate), view},
+		{"org viewer unlisted", testUser(orgViewerID), orgGallery(VisibilityUnlisted), view},
+		{"org viewer public", testUser(orgViewerID), orgGallery(VisibilityPublic), view},
+		{"moderator org private", testUser(moderatorID), orgGallery(VisibilityPrivate), moderator},
+		{"stranger org private", testUser(strangerID), orgGallery(VisibilityPrivate), none},
+		{"stranger org unlisted", testUser(strangerID), orgGallery(VisibilityUnlisted), viewLink},
+		{"stranger org public", testUser(strangerID), orgGallery(VisibilityPublic), view},
+		{"anonymous org private", nil, orgGallery(VisibilityPrivate), none},
+		{"anonymous org unlisted", nil, orgGallery(VisibilityUnlisted), viewLink},
+		{"anonymous org public", nil, orgGallery(VisibilityPublic), view},
+	}
+	p := testingPolicy(VerificationPolicy{})
+	for _, tt := range tests {
+		allowed := make(map[Permission]bool)
+		for _, perm := range tt.allowed {
+			allowed[perm] = true
+		}
+		for _, perm := range galleryPerms {
+			if got := p.Can(tt.user, perm, tt.gallery); got != allowed[perm] {
+				t.Errorf("%s: %s, Expected %v, Received %v", tt.name, perm, allowed[perm], got)
+			}
+		}
+	}
+}
+
+func TestPolicyHidesUnsharedOwners(t *testing.T) {
+	tests := []struct {
+		name    string
+		vp      VerificationPolicy
+		ownerID uint
+		want    bool
+	}{
+		{"verified owner", VerificationPolicy{BlockSharing: true}, ownerID, true},
+		{"unverified owner", VerificationPolicy{BlockSharing: true}, unverifiedID, false},
+		{"unverified owner without blocking", VerificationPolicy{}, unverifiedID, true},
+		{"owner deleting the account", VerificationPolicy{}, deletingID, false},
+	}
+	for _, tt := range tests {
+		p := testingPolicy(tt.vp)
+		for _, v := range []Visibility{VisibilityUnlisted, VisibilityPublic} {
+			gallery := &Gallery{UserID: tt.ownerID, Visibility: v}
+			if got := p.Can(nil, PermGalleryViewLink, gallery); got != tt.want {
+				t.Errorf("%s: %s gallery, Expected %v, Received %v", tt.name, v, tt.want, got)
+			}
+		}
+		owner := testUser(tt.ownerID)
+		if !p.Can(owner, PermGalleryView, &Gallery{UserID: tt.ownerID, Visibility: VisibilityPublic}) {
+			t.Errorf("%s: Expected the owner to see the gallery", tt.name)
+		}
+	}
+}
+
+func TestPolicyOrganizations(t *testing.T) {
+	org := &Organization{Model: gorm.Model{ID: testOrgID}}
+	tests := []struct {
+		name    string
+		user    *User
+		allowed []Permission
+	}{
+		{"owner", testUser(orgOwnerID), []Permission{PermOrgView, PermOrgManage, PermGalleryCreate}},
+		{"editor", testUser(orgEditorID), []Permission{PermOrgView, PermGalleryCreate}},
+		{"viewer", testUser(orgViewerID), []Permission{PermOrgView}},
+		{"stranger", testUser(strangerID), []Permission{}},
+		{"moderator", testUser(moderatorID), []Permission{}},
+		{"anonymous", nil, []Permission{}},
+	}
+	p := testingPolicy(VerificationPolicy{})
+	for _, tt := range tests {
+		allowed := make(map[Permission]bool)
+		for _, perm := range tt.allowed {
+			allowed[perm] = true
+		}
+		for _, perm := range []Permission{PermOrgView, PermOrgManage, PermGalleryCreate} {
+			if got := p.Can(tt.user, perm, org); got != allowed[perm] {
+				t.Errorf("%s: %s, Expected %v, Received %v", tt.name, perm, allowed[perm], got)
+			}
+		}
+	}
+}
+
+func TestPolicyMemberships(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *User
+		member uint
+		want   bool
+	}{
+		{"owner removes an editor", testUser(orgOwnerID), orgEditorID, true},
+		{"owner removes a viewer", testUser(orgOwnerID), orgViewerID, true},
+		{"owner leaves", testUser(orgOwnerID), orgOwnerID, true},
+		{"editor leaves", testUser(orgEditorID), orgEditorID, true},
+		{"editor removes a viewer", testUser(orgEditorID), orgViewerID, false},
+		{"editor removes the owner", testUser(orgEditorID), orgOwnerID, false},
+		{"viewer leaves", testUser(orgViewerID), orgViewerID, true},
+		{"viewer removes an editor", testUser(orgViewerID), orgEditorID, false},
+		{"stranger leaves", testUser(strangerID), strangerID, false},
+		{"stranger removes a viewer", testUser(strangerID), orgViewerID, false},
+		{"moderator removes a viewer", testUser(moderatorID), orgViewerID, false},
+		{"anonymous", nil, orgViewerID, false},
+	}
+	p := testingPolicy(VerificationPolicy{})
+	for _, tt := range tests {
+		m := &Membership{OrganizationID: testOrgID, UserID: tt.member}
+		if got := p.Can(tt.user, PermMemberRemove, m); got != tt.want {
+			t.Errorf("%s: Expected %v, Received %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+/*TestImageTokens covers visitors of an unlisted gallery, who only get to load its images
+with a token issued for the current link of the gallery*/
+func TestImageTokens(t *testing.T) {
+	keys, err := hash.NewKeyring(hash.Key{ID: "test", Secret: "secret-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := &galleryService{keys: keys}
+	gallery := personalGallery(VisibilityUnlisted)
+	gallery.Images = []Image{{GalleryID: gallery.ID, Filename: "a.jpg"}}
+	if err := gs.SignImages(gallery); err != nil {
+		t.Fatal(err)
+	}
+	token := gallery.Images[0].Token
+
+	p := testingPolicy(VerificationPolicy{})
+	if p.Can(nil, PermGalleryView, gallery) {
+		t.Error("Expected anonymous users not to view an unlisted gallery without its link")
+	}
+	if !gs.ImageTokenValid(gallery, token) || !p.Can(nil, PermGalleryViewLink, gallery) {
+		t.Error("Expected the token to let anonymous users load the images")
+	}
+	if gs.ImageTokenValid(gallery, "") {
+		t.Error("Expected images to need a token")
+	}
+	other := personalGallery(VisibilityUnlisted)
+	other.ID = 3
+	if gs.ImageTokenValid(other, token) {
+		t.Error("Expected the token to be bound to its gallery")
+	}
+	relinked := personalGallery(VisibilityUnlisted)
+	relinked.Slug = "new-slug"
+	if gs.ImageTokenValid(relinked, token) {
+		t.Error("Expected the token to stop working with a new link")
+	}
+	private := personalGallery(VisibilityPrivate)
+	if p.Can(nil, PermGalleryViewLink, private) {
+		t.Error("Expected the token not to help once the gallery is private")
+	}
+}
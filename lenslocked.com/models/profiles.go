@@ -0,0 +1,111 @@
+package models
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	bioMaxLength = 500
+	maxLinks     = 5
+)
+
+var usernameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,28}[a-z0-9]$`)
+
+/*reservedUsernames can't be taken because they are, or may become, part of our own URLs
+or could be mistaken for the staff*/
+var reservedUsernames = map[string]bool{
+	"about": true, "account": true, "admin": true, "administrator": true, "api": true,
+	"assets": true, "contact": true, "galleries": true, "help": true, "images": true,
+	"invitations": true, "lenslocked": true, "login": true, "logout": true, "me": true,
+	"moderator": true, "new": true, "oauth": true, "recovery": true, "reset": true,
+	"root": true, "security": true, "settings": true, "signin": true, "signup": true,
+	"staff": true, "support": true, "system": true, "u": true, "unlock": true,
+	"user": true, "users": true, "verify": true, "www": true,
+}
+
+/*ProfileURL is the public portfolio page, empty until the user picked a username*/
+func (u *User) ProfileURL() string {
+	if u.Username == "" {
+		return ""
+	}
+	return "/u/" + url.PathEscape(u.Username)
+}
+
+/*LinkList returns the website links, Links stores one per line*/
+func (u *User) LinkList() []string {
+	return strings.Fields(u.Links)
+}
+
+/*AvatarPath is the URL of the avatar image, empty if the user has none*/
+func (u *User) AvatarPath() string {
+	if u.AvatarFilename == "" {
+		return ""
+	}
+	img := Avatar{UserID: u.ID, Filename: u.AvatarFilename}
+	return img.Path()
+}
+
+/*normalizeUsername lowercases usernames, so they are unique regardless of case*/
+func (uv *userValidator) normalizeUsername(user *User) error {
+	user.Username = strings.ToLower(strings.TrimSpace(user.Username))
+	return nil
+}
+
+/*usernameFormat allows users to go without a username, they just don't get a profile page*/
+func (uv *userValidator) usernameFormat(user *User) error {
+	if user.Username == "" {
+		return nil
+	}
+	if !usernameRegex.MatchString(user.Username) {
+		return ErrUsernameInvalid
+	}
+	if reservedUsernames[user.Username] {
+		return ErrUsernameReserved
+	}
+	return nil
+}
+
+func (uv *userValidator) usernameIsAvail(user *User) error {
+	if user.Username == "" {
+		return nil
+	}
+	existing, err := uv.ByUsername(user.Username)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.ID != existing.ID {
+		return ErrUsernameTaken
+	}
+	return nil
+}
+
+func (uv *userValidator) bioLength(user *User) error {
+	user.Bio = strings.TrimSpace(user.Bio)
+	if utf8.RuneCountInString(user.Bio) > bioMaxLength {
+		return ErrBioTooLong
+	}
+	return nil
+}
+
+/*normalizeLinks only accepts absolute http and https addresses, anything else could run
+scripts when clicked on the profile page*/
+func (uv *userValidator) normalizeLinks(user *User) error {
+	links := user.LinkList()
+	if len(links) > maxLinks {
+		return ErrTooManyLinks
+	}
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrLinkInvalid
+		}
+	}
+	user.Links = strings.Join(links, "\n")
+	return nil
+}
@@ -4,8 +4,48 @@ import (
 	"github.com/username/project-name/hash"
 	"github.com/username/project-name/rand"
 	"gorm.io/gorm"
+	"time"
 )
 
+const (
+	defaultResetTTL   = 12 * time.Hour
+	defaultResetLimit = 3
+	/*resetWindow is the period ResetPolicy.Limit applies to*/
+	resetWindow = time.Hour
+	/*tokenRetention is how long used and expired tokens are kept, rate limits count them*/
+	tokenRetention = 24 * time.Hour
+)
+
+/*ResetPolicy configures password reset links. Zero values fall back to the defaults*/
+type ResetPolicy struct {
+	/*TTLMinutes is how long a reset link can be used*/
+	TTLMinutes int `json:"ttl_minutes"`
+	/*Limit is how many links can be requested for an account per hour*/
+	Limit int `json:"limit"`
+}
+
+func (p ResetPolicy) withDefaults() ResetPolicy {
+	if p.TTLMinutes <= 0 {
+		p.TTLMinutes = int(defaultResetTTL / time.Minute)
+	}
+	if p.Limit <= 0 {
+		p.Limit = defaultResetLimit
+	}
+	return p
+}
+
+func (p ResetPolicy) ttl() time.Duration {
+	return time.Duration(p.TTLMinutes) * time.Minute
+}
+
+/*retention keeps reset tokens while they can still be used or counted*/
+func (p ResetPolicy) retention() time.Duration {
+	if p.ttl() > tokenRetention {
+		return p.ttl()
+	}
+	return tokenRetention
+}
+
 type pwReset struct {
 	gorm.Model
 	UserID    uint   `gorm:"not null"`
@@ -17,9 +57,15 @@ type pwResetDB interface {
 	ByToken(token string) (*pwReset, error)
 	Create(pw *pwReset) error
 	Delete(id uint) error
+	/*DeleteByUserID invalidates every reset token of the user*/
+	DeleteByUserID(userID uint) error
+	/*CountSince counts the tokens issued after the provided time, invalidated ones included*/
+	CountSince(userID uint, since time.Time) (int64, error)
+	/*Purge removes the rows of tokens created before the provided time for good*/
+	Purge(before time.Time) error
 }
 
-func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
+func newPwResetValidator(db pwResetDB, hmac *hash.Keyring) *pwResetValidator {
 	return &pwResetValidator{
 		pwResetDB: db,
 		hmac:      hmac,
@@ -28,16 +74,18 @@ func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
 
 type pwResetValidator struct {
 	pwResetDB
-	hmac hash.HMAC
+	hmac *hash.Keyring
 }
 
+/*ByToken accepts tokens hashed with a retired key as well. Reset tokens are deleted once
+used, so they are not hashed again*/
 func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
-	pwr := pwReset{Token: token}
-	err := runPwResetValFns(&pwr, pwrv.hmacToken)
-	if err != nil {
-		return nil, err
-	}
-	return pwrv.pwResetDB.ByToken(pwr.TokenHash)
+	var pwr *pwReset
+	_, err := findHashed(pwrv.hmac, token, func(tokenHash string) (err error) {
+		pwr, err = pwrv.pwResetDB.ByToken(tokenHash)
+		return err
+	})
+	return pwr, err
 }
 
 func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
@@ -59,6 +107,13 @@ func (pwrv *pwResetValidator) Delete(id uint) error {
 	return pwrv.pwResetDB.Delete(id)
 }
 
+func (pwrv *pwResetValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return pwrv.pwResetDB.DeleteByUserID(userID)
+}
+
 type pwResetGorm struct {
 	db *gorm.DB
 }
@@ -81,6 +136,22 @@ func (pwrg *pwResetGorm) Delete(id uint) error {
 	return pwrg.db.Delete(&pwr).Error
 }
 
+func (pwrg *pwResetGorm) DeleteByUserID(userID uint) error {
+	return pwrg.db.Where("user_id = ?", userID).Delete(&pwReset{}).Error
+}
+
+func (pwrg *pwResetGorm) CountSince(userID uint, since time.Time) (int64, error) {
+	var n int64
+	err := pwrg.db.Unscoped().Model(&pwReset{}).
+		Where("user_id = ? AND created_at > ?", userID, since).
+		Count(&n).Error
+	return n, err
+}
+
+func (pwrg *pwResetGorm) Purge(before time.Time) error {
+	return pwrg.db.Unscoped().Where("created_at < ?", before).Delete(&pwReset{}).Error
+}
+
 func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
 	if pwr.UserID <= 0 {
 		return ErrUserIDRequired
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"fmt"
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/password"
 	"gorm.io/gorm"
+	"time"
 )
 
 type ServicesConfig func(*Services) error
@@ -17,16 +21,116 @@ func WithGorm(dialect gorm.Dialector) ServicesConfig {
 	}
 }
 
-func WithUser(hmacKey string) ServicesConfig {
+/*WithAttempts selects where failed sign in attempts are counted, "memory" or "postgres".
+It has to come before WithUser, without it the counters are kept in postgres*/
+func WithAttempts(store string) ServicesConfig {
 	return func(s *Services) error {
-		s.User = NewUserService(s.db, hmacKey)
+		switch store {
+		case "memory":
+			s.attempts = NewMemoryAttemptStore()
+		case "", "postgres":
+			s.attempts = NewAttemptGorm(s.db)
+		default:
+			return fmt.Errorf("models: unknown attempt store %q", store)
+		}
 		return nil
 	}
 }
 
-func WithGallery() ServicesConfig {
+/*WithPasswordHashing selects the algorithm for new password hashes. It has to come before
+WithUser, without it passwords are hashed with the argon2id defaults*/
+func WithPasswordHashing(cfg password.Config) ServicesConfig {
 	return func(s *Services) error {
-		s.Gallery = NewGalleryService(s.db)
+		hasher, err := cfg.Hasher()
+		if err != nil {
+			return err
+		}
+		s.hasher = hasher
+		return nil
+	}
+}
+
+/*WithPasswordPolicy sets the rules for new passwords. It has to come before WithUser*/
+func WithPasswordPolicy(policy PasswordPolicy) ServicesConfig {
+	return func(s *Services) error {
+		s.passwordPolicy = policy
+		return nil
+	}
+}
+
+/*WithPasswordReset configures reset links. It has to come before WithUser*/
+func WithPasswordReset(policy ResetPolicy) ServicesConfig {
+	return func(s *Services) error {
+		s.resetPolicy = policy
+		return nil
+	}
+}
+
+func WithUser(keys *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		if s.attempts == nil {
+			s.attempts = NewAttemptGorm(s.db)
+		}
+		if s.hasher == nil {
+			s.hasher = password.DefaultArgon2id
+		}
+		s.User = NewUserService(s.db, keys, s.attempts, s.hasher, s.passwordPolicy, s.resetPolicy)
+		return nil
+	}
+}
+
+/*WithSession takes how long "keep me signed in" sessions last without being used*/
+func WithSession(keys *hash.Keyring, lifetime time.Duration) ServicesConfig {
+	return func(s *Services) error {
+		s.Session = NewSessionService(s.db, keys, lifetime)
+		return nil
+	}
+}
+
+func WithAPIToken(keys *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.APIToken = NewAPITokenService(s.db, keys)
+		return nil
+	}
+}
+
+func WithInvitation(keys *hash.Keyring, mode RegistrationMode) ServicesConfig {
+	return func(s *Services) error {
+		if mode != "" && !mode.Valid() {
+			return fmt.Errorf("models: unknown registration mode %q", mode)
+		}
+		s.Invitation = NewInvitationService(s.db, keys, mode)
+		return nil
+	}
+}
+
+/*WithIdentity has to come after WithUser and WithInvitation*/
+func WithIdentity() ServicesConfig {
+	return func(s *Services) error {
+		s.Identity = NewIdentityService(s.db, s.User, s.Invitation)
+		return nil
+	}
+}
+
+/*WithOrganization has to come after WithUser*/
+func WithOrganization(keys *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.Organization = NewOrganizationService(s.db, keys, s.User)
+		return nil
+	}
+}
+
+/*WithPolicy has to come after WithUser and WithOrganization*/
+func WithPolicy(vp VerificationPolicy) ServicesConfig {
+	return func(s *Services) error {
+		s.Policy = NewPolicy(s.User, s.Organization, vp)
+		return nil
+	}
+}
+
+func WithGallery(keys *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.Gallery = NewGalleryService(s.db, keys)
 		return nil
 	}
 }
@@ -38,6 +142,36 @@ func WithImage() ServicesConfig {
 	}
 }
 
+/*WithAccount has to come after WithGallery, WithImage and WithOrganization*/
+func WithAccount() ServicesConfig {
+	return func(s *Services) error {
+		s.Account = NewAccountService(s.db, s.Gallery, s.Image, s.Organization)
+		return nil
+	}
+}
+
+func WithAudit() ServicesConfig {
+	return func(s *Services) error {
+		s.Audit = NewAuditService(s.db)
+		return nil
+	}
+}
+
+func WithLoginEvent(keys *hash.Keyring) ServicesConfig {
+	return func(s *Services) error {
+		s.LoginEvent = NewLoginEventService(s.db, keys)
+		return nil
+	}
+}
+
+/*WithAdmin has to come after WithImage*/
+func WithAdmin() ServicesConfig {
+	return func(s *Services) error {
+		s.Admin = NewAdminService(s.db, s.Image)
+		return nil
+	}
+}
+
 func NewServices(cfgs ...func(*Services) error) (*Services, error) {
 	var s Services
 	for _, cfg := range cfgs {
@@ -49,15 +183,32 @@ func NewServices(cfgs ...func(*Services) error) (*Services, error) {
 }
 
 type Services struct {
-	Gallery GalleryService
-	User    UserService
-	Image   ImageService
-	db      *gorm.DB
+	Gallery      GalleryService
+	User         UserService
+	Session      SessionService
+	APIToken     APITokenService
+	Identity     IdentityService
+	Invitation   InvitationService
+	Image        ImageService
+	Account      AccountService
+	Admin        AdminService
+	Audit        AuditService
+	LoginEvent   LoginEventService
+	Organization OrganizationService
+	Policy       *Policy
+	db           *gorm.DB
+	attempts     AttemptStore
+	hasher       password.Hasher
+
+	passwordPolicy PasswordPolicy
+	resetPolicy    ResetPolicy
 }
 
 /*ResetDB drops all tables and then recreates them*/
 func (s *Services) ResetDB() error {
-	s.db.Migrator().DropTable(&User{}, &Gallery{}, &pwReset{})
+	s.db.Migrator().DropTable(&User{}, &Session{}, &Gallery{}, &pwReset{}, &recoveryCode{}, &mfaChallenge{},
+		&userToken{}, &Identity{}, &loginAttempt{}, &APIToken{}, &Invitation{}, &AuditEvent{},
+		&LoginEvent{}, &Organization{}, &Membership{}, &OrgInvitation{})
 	if err := s.db.AutoMigrate(); err != nil {
 		return err
 	}
@@ -66,5 +217,14 @@ func (s *Services) ResetDB() error {
 
 /*AutoMigrate will create tables, indexes, etc */
 func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}, &pwReset{})
+	/*remember tokens used to live on the users table. They moved to sessions and the old
+	not null column would otherwise reject every new user*/
+	if s.db.Migrator().HasColumn(&User{}, "remember_hash") {
+		if err := s.db.Migrator().DropColumn(&User{}, "remember_hash"); err != nil {
+			return err
+		}
+	}
+	return s.db.AutoMigrate(&User{}, &Session{}, &Gallery{}, &pwReset{}, &recoveryCode{}, &mfaChallenge{},
+		&userToken{}, &Identity{}, &loginAttempt{}, &APIToken{}, &Invitation{}, &AuditEvent{},
+		&LoginEvent{}, &Organization{}, &Membership{}, &OrgInvitation{})
 }
@@ -0,0 +1,375 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	/*DefaultSessionLifetime is how long a "keep me signed in" session stays valid without being used*/
+	DefaultSessionLifetime = 30 * 24 * time.Hour
+	/*browserSessionLifetime is how long any other session stays valid without being used.
+	Its cookie is gone once the browser is closed anyway*/
+	browserSessionLifetime = 12 * time.Hour
+	/*ImpersonationLifetime is how long an admin stays signed in as another user*/
+	ImpersonationLifetime = time.Hour
+	/*sessionTouchInterval limits how often LastSeenAt is written so that we don't
+	hit the database on every single request*/
+	sessionTouchInterval = 5 * time.Minute
+)
+
+/*Session represents a single signed in device. Every successful sign-in creates a new
+session so that a user can stay logged in on several devices and revoke each of them separately*/
+type Session struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;uniqueIndex"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	/*AuthenticatedAt is when the user last proved who they are on this session, by signing
+	in or confirming the password later on*/
+	AuthenticatedAt time.Time
+	/*ImpersonatorID is set on sessions an admin opened to see the site as the user. Such
+	sessions only work alongside the admin's own session and aren't listed to the user*/
+	ImpersonatorID uint `gorm:"not null;default:0;index"`
+	/*Persistent sessions were asked to keep the user signed in, their cookie outlives the browser*/
+	Persistent bool `gorm:"not null;default:false"`
+}
+
+/*AuthenticatedWithin reports whether the user proved who they are within d*/
+func (s *Session) AuthenticatedWithin(d time.Duration) bool {
+	return time.Since(s.AuthenticatedAt) < d
+}
+
+func (s *Session) Impersonated() bool {
+	return s.ImpersonatorID != 0
+}
+
+func (s *Session) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+/*SessionDB is used to interact with the sessions table*/
+type SessionDB interface {
+	ByID(id uint) (*Session, error)
+	ByToken(token string) (*Session, error)
+	ByUserID(userID uint) ([]Session, error)
+
+	Create(session *Session) error
+	Update(session *Session) error
+	Delete(id uint) error
+	/*DeleteByUserID removes every session of the user except the one with the keepID.
+	Pass 0 to sign the user out everywhere*/
+	DeleteByUserID(userID, keepID uint) error
+	/*Rehash replaces the token hash after the HMAC key was rotated*/
+	Rehash(id uint, tokenHash string) error
+}
+
+/*SessionService is a set of methods used to manage the devices a user is signed in with*/
+type SessionService interface {
+	/*Touch records that the session has just been used and pushes its expiry back. It
+	reports whether the session was renewed, so that the cookie can be renewed as well*/
+	Touch(session *Session) (bool, error)
+	SessionDB
+}
+
+/*NewSessionService creates the service, lifetime is how long persistent sessions last
+without being used*/
+func NewSessionService(db *gorm.DB, keys *hash.Keyring, lifetime time.Duration) SessionService {
+	if lifetime <= 0 {
+		lifetime = DefaultSessionLifetime
+	}
+	return &sessionService{
+		SessionDB: newSessionValidator(&sessionGorm{db}, keys),
+		audit:     &auditValidator{&auditGorm{db}},
+		lifetime:  lifetime,
+	}
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+	audit    AuditDB
+	lifetime time.Duration
+}
+
+/*expiry is when the session expires if it isn't used again from now on*/
+func (ss *sessionService) expiry(session *Session) time.Time {
+	if session.Persistent {
+		return time.Now().Add(ss.lifetime)
+	}
+	return time.Now().Add(browserSessionLifetime)
+}
+
+/*Create leaves the expiry alone if it is set, like the fixed one of impersonation sessions*/
+func (ss *sessionService) Create(session *Session) error {
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = ss.expiry(session)
+	}
+	return ss.SessionDB.Create(session)
+}
+
+/*ByToken looks up an active session. Expired sessions are removed and reported as an invalid
+token, the end of an impersonation that ran out is added to the audit log*/
+func (ss *sessionService) ByToken(token string) (*Session, error) {
+	session, err := ss.SessionDB.ByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if session.Expired() {
+		if err := ss.SessionDB.Delete(session.ID); err == nil && session.Impersonated() {
+			ss.audit.Create(&AuditEvent{
+				ActorID:   session.ImpersonatorID,
+				SubjectID: session.UserID,
+				Action:    AuditImpersonationStop,
+				Detail:    "expired",
+				IP:        session.IP,
+			})
+		}
+		return nil, ErrInvalidToken
+	}
+	return session, nil
+}
+
+/*ByUserID returns active sessions only, the most recently used first*/
+func (ss *sessionService) ByUserID(userID uint) ([]Session, error) {
+	sessions, err := ss.SessionDB.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		if !s.Expired() {
+			ret = append(ret, s)
+		}
+	}
+	return ret, nil
+}
+
+/*Touch never extends impersonation sessions, they end after ImpersonationLifetime*/
+func (ss *sessionService) Touch(session *Session) (bool, error) {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return false, nil
+	}
+	session.LastSeenAt = time.Now()
+	if !session.Impersonated() {
+		session.ExpiresAt = ss.expiry(session)
+	}
+	if err := ss.Update(session); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type sessionValFunc func(*Session) error
+
+func runSessionValFuncs(session *Session, fns ...sessionValFunc) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionValidator{}
+
+func newSessionValidator(db SessionDB, hmac *hash.Keyring) *sessionValidator {
+	return &sessionValidator{
+		SessionDB: db,
+		hmac:      hmac,
+	}
+}
+
+type sessionValidator struct {
+	SessionDB
+	hmac *hash.Keyring
+}
+
+/*ByToken also accepts tokens hashed with a retired key, they are hashed again with the active one*/
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	var session *Session
+	stale, err := findHashed(sv.hmac, token, func(tokenHash string) (err error) {
+		session, err = sv.SessionDB.ByToken(tokenHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		session.TokenHash = sv.hmac.Hash(token)
+		/*should this fail the retired key is tried again next time*/
+		sv.SessionDB.Rehash(session.ID, session.TokenHash)
+	}
+	return session, nil
+}
+
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFuncs(session,
+		sv.requireUserID,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired,
+		sv.setTimesIfUnset,
+		sv.requireExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) Update(session *Session) error {
+	err := runSessionValFuncs(session,
+		sv.requireUserID,
+		sv.tokenHashRequired,
+	)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Update(session)
+}
+
+func (sv *sessionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return sv.SessionDB.Delete(id)
+}
+
+func (sv *sessionValidator) DeleteByUserID(userID, keepID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return sv.SessionDB.DeleteByUserID(userID, keepID)
+}
+
+func (sv *sessionValidator) requireUserID(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) tokenMinBytes(session *Session) error {
+	n, err := rand.NBytes(session.Token)
+	if err != nil {
+		return err
+	}
+	if n < 32 {
+		return ErrRememberIsShort
+	}
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+func (sv *sessionValidator) tokenHashRequired(session *Session) error {
+	if session.TokenHash == "" {
+		return ErrRememberHashIsRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTimesIfUnset(session *Session) error {
+	now := time.Now()
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = now
+	}
+	if session.AuthenticatedAt.IsZero() {
+		session.AuthenticatedAt = now
+	}
+	return nil
+}
+
+func (sv *sessionValidator) requireExpiresAt(session *Session) error {
+	if session.ExpiresAt.IsZero() {
+		return ErrTokenExpiryRequired
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+func (sg *sessionGorm) ByID(id uint) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("id = ?", id), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	if tokenHash == "" {
+		return nil, ErrInvalidToken
+	}
+	var session Session
+	err := first(sg.db.Where("token_hash = ?", tokenHash), &session)
+	if err == ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ? AND impersonator_id = 0", userID).Order("last_seen_at desc").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+func (sg *sessionGorm) Delete(id uint) error {
+	session := Session{Model: gorm.Model{ID: id}}
+	return sg.db.Delete(&session).Error
+}
+
+func (sg *sessionGorm) Rehash(id uint, tokenHash string) error {
+	return rehash(sg.db, &Session{}, id, "token_hash", tokenHash)
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID, keepID uint) error {
+	return sg.db.Where("user_id = ? AND id <> ?", userID, keepID).Delete(&Session{}).Error
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+/*fakeSessionDB holds a single session*/
+type fakeSessionDB struct {
+	SessionDB
+	session *Session
+	deleted bool
+}
+
+func (db *fakeSessionDB) ByToken(token string) (*Session, error) {
+	if db.deleted || token != "token" {
+		return nil, ErrNotFound
+	}
+	return db.session, nil
+}
+
+func (db *fakeSessionDB) Delete(id uint) error {
+	db.deleted = true
+	return nil
+}
+
+type fakeAuditDB struct {
+	AuditDB
+	events []AuditEvent
+}
+
+func (db *fakeAuditDB) Create(event *AuditEvent) error {
+	db.events = append(db.events, *event)
+	return nil
+}
+
+func TestExpiredImpersonationIsAudited(t *testing.T) {
+	tests := []struct {
+		name    string
+		session Session
+		want    int
+	}{
+		{"active impersonation", Session{ImpersonatorID: 1, ExpiresAt: time.Now().Add(time.Hour)}, 0},
+		{"expired impersonation", Session{ImpersonatorID: 1, ExpiresAt: time.Now().Add(-time.Minute)}, 1},
+		{"expired session", Session{ExpiresAt: time.Now().Add(-time.Minute)}, 0},
+	}
+	for _, tt := range tests {
+		tt.session.Model = gorm.Model{ID: 3}
+		tt.session.UserID = 2
+		audit := &fakeAuditDB{}
+		ss := &sessionService{SessionDB: &fakeSessionDB{session: &tt.session}, audit: audit}
+		ss.ByToken("token")
+		if len(audit.events) != tt.want {
+			t.Fatalf("%s: Expected %d audit events, Received %+v", tt.name, tt.want, audit.events)
+		}
+		if tt.want == 1 {
+			e := audit.events[0]
+			if e.Action != AuditImpersonationStop || e.ActorID != 1 || e.SubjectID != 2 {
+				t.Errorf("%s: Expected the end of the impersonation, Received %+v", tt.name, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+const (
+	/*signInLinkTTL is how long an emailed sign-in link can be used*/
+	signInLinkTTL = 15 * time.Minute
+	/*signInLinkLimit is how many links can be requested for an address within signInLinkWindow*/
+	signInLinkLimit  = 3
+	signInLinkWindow = time.Hour
+)
+
+/*InitiateSignIn issues a single-use token that signs the user with the provided email in
+without a password. ErrTooManyRequests is returned once too many links were requested*/
+func (us *userService) InitiateSignIn(email string) (string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	n, err := us.userTokenDB.CountSince(user.ID, tokenSignIn, time.Now().Add(-signInLinkWindow))
+	if err != nil {
+		return "", err
+	}
+	if n >= signInLinkLimit {
+		return "", ErrTooManyRequests
+	}
+	ut := userToken{
+		UserID:    user.ID,
+		Purpose:   tokenSignIn,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(signInLinkTTL),
+	}
+	if err := us.userTokenDB.Create(&ut); err != nil {
+		return "", err
+	}
+	return ut.Token, nil
+}
+
+/*CompleteSignIn consumes a sign-in token. Following the link proves the user owns the
+email address so it is marked as verified as well*/
+func (us *userService) CompleteSignIn(token string) (*User, error) {
+	ut, err := us.consumeToken(tokenSignIn, token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(ut.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != ut.Email {
+		return nil, ErrTokenInvalid
+	}
+	if user.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	if !user.EmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := us.Update(user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"encoding/base32"
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	/*recoveryCodeCount is how many single-use recovery codes a user receives*/
+	recoveryCodeCount = 10
+	/*mfaChallengeTTL is how long a user has to enter the second factor after the password was accepted*/
+	mfaChallengeTTL = 5 * time.Minute
+	/*mfaMaxAttempts is how many wrong codes are accepted for a single challenge*/
+	mfaMaxAttempts = 5
+)
+
+/*recoveryCode is a single-use code that can stand in for a TOTP code when the device is lost*/
+type recoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Code     string `gorm:"-"`
+	CodeHash string `gorm:"not null;uniqueIndex"`
+}
+
+/*mfaChallenge is created once the password of a user with two-factor authentication was verified.
+Its token is all the browser holds until the second factor passes*/
+type mfaChallenge struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;uniqueIndex"`
+	Attempts  int
+}
+
+type recoveryCodeDB interface {
+	ByCode(userID uint, code string) (*recoveryCode, error)
+	Create(rc *recoveryCode) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+	/*Rehash replaces the code hash after the HMAC key was rotated*/
+	Rehash(id uint, codeHash string) error
+}
+
+type mfaChallengeDB interface {
+	ByToken(token string) (*mfaChallenge, error)
+	Create(c *mfaChallenge) error
+	Update(c *mfaChallenge) error
+	Delete(id uint) error
+}
+
+func newRecoveryCodeValidator(db recoveryCodeDB, hmac *hash.Keyring) *recoveryCodeValidator {
+	return &recoveryCodeValidator{
+		recoveryCodeDB: db,
+		hmac:           hmac,
+	}
+}
+
+type recoveryCodeValidator struct {
+	recoveryCodeDB
+	hmac *hash.Keyring
+}
+
+func (rcv *recoveryCodeValidator) ByCode(userID uint, code string) (*recoveryCode, error) {
+	rc := recoveryCode{UserID: userID, Code: code}
+	if err := runRecoveryCodeValFns(&rc, rcv.requireUserID, rcv.normalizeCode); err != nil {
+		return nil, err
+	}
+	plain := strings.ReplaceAll(rc.Code, "-", "")
+	var found *recoveryCode
+	stale, err := findHashed(rcv.hmac, plain, func(codeHash string) (err error) {
+		found, err = rcv.recoveryCodeDB.ByCode(rc.UserID, codeHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		found.CodeHash = rcv.hmac.Hash(plain)
+		rcv.recoveryCodeDB.Rehash(found.ID, found.CodeHash)
+	}
+	return found, nil
+}
+
+func (rcv *recoveryCodeValidator) Create(rc *recoveryCode) error {
+	err := runRecoveryCodeValFns(rc,
+		rcv.requireUserID,
+		rcv.setCodeIfUnset,
+		rcv.normalizeCode,
+		rcv.hmacCode,
+	)
+	if err != nil {
+		return err
+	}
+	return rcv.recoveryCodeDB.Create(rc)
+}
+
+func (rcv *recoveryCodeValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return rcv.recoveryCodeDB.Delete(id)
+}
+
+func (rcv *recoveryCodeValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return rcv.recoveryCodeDB.DeleteByUserID(userID)
+}
+
+func (rcv *recoveryCodeValidator) requireUserID(rc *recoveryCode) error {
+	if rc.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+/*setCodeIfUnset generates a code that is easy to type, e.g. "k3j2m-9xq4p"*/
+func (rcv *recoveryCodeValidator) setCodeIfUnset(rc *recoveryCode) error {
+	if rc.Code != "" {
+		return nil
+	}
+	b, err := rand.Bytes(7)
+	if err != nil {
+		return err
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	rc.Code = s[:5] + "-" + s[5:]
+	return nil
+}
+
+func (rcv *recoveryCodeValidator) normalizeCode(rc *recoveryCode) error {
+	rc.Code = strings.ToLower(strings.TrimSpace(rc.Code))
+	return nil
+}
+
+func (rcv *recoveryCodeValidator) hmacCode(rc *recoveryCode) error {
+	if rc.Code == "" {
+		return nil
+	}
+	rc.CodeHash = rcv.hmac.Hash(strings.ReplaceAll(rc.Code, "-", ""))
+	return nil
+}
+
+type recoveryCodeValFn func(*recoveryCode) error
+
+func runRecoveryCodeValFns(rc *recoveryCode, fns ...recoveryCodeValFn) error {
+	for _, fn := range fns {
+		if err := fn(rc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type recoveryCodeGorm struct {
+	db *gorm.DB
+}
+
+func (rcg *recoveryCodeGorm) ByCode(userID uint, codeHash string) (*recoveryCode, error) {
+	var rc recoveryCode
+	err := first(rcg.db.Where("user_id = ? AND code_hash = ?", userID, codeHash), &rc)
+	if err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+func (rcg *recoveryCodeGorm) Create(rc *recoveryCode) error {
+	return rcg.db.Create(rc).Error
+}
+
+func (rcg *recoveryCodeGorm) Delete(id uint) error {
+	rc := recoveryCode{Model: gorm.Model{ID: id}}
+	return rcg.db.Delete(&rc).Error
+}
+
+func (rcg *recoveryCodeGorm) DeleteByUserID(userID uint) error {
+	return rcg.db.Where("user_id = ?", userID).Delete(&recoveryCode{}).Error
+}
+
+func (rcg *recoveryCodeGorm) Rehash(id uint, codeHash string) error {
+	return rehash(rcg.db, &recoveryCode{}, id, "code_hash", codeHash)
+}
+
+func newMfaChallengeValidator(db mfaChallengeDB, hmac *hash.Keyring) *mfaChallengeValidator {
+	return &mfaChallengeValidator{
+		mfaChallengeDB: db,
+		hmac:           hmac,
+	}
+}
+
+type mfaChallengeValidator struct {
+	mfaChallengeDB
+	hmac *hash.Keyring
+}
+
+/*ByToken accepts tokens hashed with a retired key as well. Challenges are short-lived,
+so they are not hashed again*/
+func (mcv *mfaChallengeValidator) ByToken(token string) (*mfaChallenge, error) {
+	if token == "" {
+		return nil, ErrTokenInvalid
+	}
+	var c *mfaChallenge
+	_, err := findHashed(mcv.hmac, token, func(tokenHash string) (err error) {
+		c, err = mcv.mfaChallengeDB.ByToken(tokenHash)
+		return err
+	})
+	return c, err
+}
+
+func (mcv *mfaChallengeValidator) Create(c *mfaChallenge) error {
+	err := runMfaChallengeValFns(c,
+		mcv.requireUserID,
+		mcv.setTokenIfUnset,
+		mcv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return mcv.mfaChallengeDB.Create(c)
+}
+
+func (mcv *mfaChallengeValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return mcv.mfaChallengeDB.Delete(id)
+}
+
+func (mcv *mfaChallengeValidator) requireUserID(c *mfaChallenge) error {
+	if c.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (mcv *mfaChallengeValidator) setTokenIfUnset(c *mfaChallenge) error {
+	if c.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	c.Token = token
+	return nil
+}
+
+func (mcv *mfaChallengeValidator) hmacToken(c *mfaChallenge) error {
+	if c.Token == "" {
+		return nil
+	}
+	c.TokenHash = mcv.hmac.Hash(c.Token)
+	return nil
+}
+
+type mfaChallengeValFn func(*mfaChallenge) error
+
+func runMfaChallengeValFns(c *mfaChallenge, fns ...mfaChallengeValFn) error {
+	for _, fn := range fns {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mfaChallengeGorm struct {
+	db *gorm.DB
+}
+
+func (mcg *mfaChallengeGorm) ByToken(tokenHash string) (*mfaChallenge, error) {
+	var c mfaChallenge
+	err := first(mcg.db.Where("token_hash = ?", tokenHash), &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (mcg *mfaChallengeGorm) Create(c *mfaChallenge) error {
+	return mcg.db.Create(c).Error
+}
+
+func (mcg *mfaChallengeGorm) Update(c *mfaChallenge) error {
+	return mcg.db.Save(c).Error
+}
+
+func (mcg *mfaChallengeGorm) Delete(id uint) error {
+	c := mfaChallenge{Model: gorm.Model{ID: id}}
+	return mcg.db.Delete(&c).Error
+}
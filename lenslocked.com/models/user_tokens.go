@@ -0,0 +1,242 @@
+package models
+
+import (
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/rand"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	/*tokenVerifyEmail proves the user can receive mail at the address stored in Email*/
+	tokenVerifyEmail = "verify_email"
+	/*tokenSignIn signs the user in without a password*/
+	tokenSignIn = "sign_in"
+	/*tokenUnlock lifts the lockout after too many failed sign in attempts*/
+	tokenUnlock = "unlock"
+	/*tokenChangeEmail is sent to the new address, Email is the address to switch to*/
+	tokenChangeEmail = "change_email"
+	/*tokenRevertEmail is sent to the old address, Email is the address to switch back to*/
+	tokenRevertEmail = "revert_email"
+)
+
+/*userToken is a single-use token emailed to a user. Like pwReset only the HMAC of the
+token is stored. Purpose keeps tokens of one flow from being accepted by another one*/
+type userToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Purpose   string `gorm:"not null"`
+	Email     string
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (ut *userToken) Expired() bool {
+	return time.Now().After(ut.ExpiresAt)
+}
+
+type userTokenDB interface {
+	ByToken(purpose, token string) (*userToken, error)
+	Create(ut *userToken) error
+	/*Delete returns ErrNotFound if the token was already gone, so whoever deletes
+	a token first is the only one allowed to use it*/
+	Delete(id uint) error
+	/*DeleteByUserID removes all tokens of the user issued for the purpose*/
+	DeleteByUserID(userID uint, purpose string) error
+	/*DeleteAllByUserID removes the tokens of the user whatever their purpose*/
+	DeleteAllByUserID(userID uint) error
+	/*CountSince counts the tokens issued for the purpose after the provided time, used ones included*/
+	CountSince(userID uint, purpose string, since time.Time) (int64, error)
+	/*Purge removes the rows of expired tokens created before the provided time for good*/
+	Purge(before time.Time) error
+}
+
+/*consumeToken looks the token up and deletes it. Whoever deletes it first wins, so
+a token can't be used twice even by concurrent requests*/
+func (us *userService) consumeToken(purpose, token string) (*userToken, error) {
+	ut, err := us.userTokenDB.ByToken(purpose, token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if err := us.userTokenDB.Delete(ut.ID); err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if ut.Expired() {
+		return nil, ErrTokenInvalid
+	}
+	return ut, nil
+}
+
+func newUserTokenValidator(db userTokenDB, hmac *hash.Keyring) *userTokenValidator {
+	return &userTokenValidator{
+		userTokenDB: db,
+		hmac:        hmac,
+	}
+}
+
+type userTokenValidator struct {
+	userTokenDB
+	hmac *hash.Keyring
+}
+
+/*ByToken accepts tokens hashed with a retired key as well. They are single-use, so
+they are not hashed again*/
+func (utv *userTokenValidator) ByToken(purpose, token string) (*userToken, error) {
+	ut := userToken{Purpose: purpose, Token: token}
+	if err := runUserTokenValFns(&ut, utv.requirePurpose); err != nil {
+		return nil, err
+	}
+	if ut.Token == "" {
+		return nil, ErrTokenInvalid
+	}
+	var found *userToken
+	_, err := findHashed(utv.hmac, ut.Token, func(tokenHash string) (err error) {
+		found, err = utv.userTokenDB.ByToken(ut.Purpose, tokenHash)
+		return err
+	})
+	return found, err
+}
+
+func (utv *userTokenValidator) Create(ut *userToken) error {
+	err := runUserTokenValFns(ut,
+		utv.requireUserID,
+		utv.requirePurpose,
+		utv.requireExpiresAt,
+		utv.setTokenIfUnset,
+		utv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return utv.userTokenDB.Create(ut)
+}
+
+func (utv *userTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return utv.userTokenDB.Delete(id)
+}
+
+func (utv *userTokenValidator) DeleteByUserID(userID uint, purpose string) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return utv.userTokenDB.DeleteByUserID(userID, purpose)
+}
+
+func (utv *userTokenValidator) DeleteAllByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return utv.userTokenDB.DeleteAllByUserID(userID)
+}
+
+func (utv *userTokenValidator) requireUserID(ut *userToken) error {
+	if ut.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (utv *userTokenValidator) requirePurpose(ut *userToken) error {
+	if ut.Purpose == "" {
+		return ErrTokenPurposeRequired
+	}
+	return nil
+}
+
+func (utv *userTokenValidator) requireExpiresAt(ut *userToken) error {
+	if ut.ExpiresAt.IsZero() {
+		return ErrTokenExpiryRequired
+	}
+	return nil
+}
+
+func (utv *userTokenValidator) setTokenIfUnset(ut *userToken) error {
+	if ut.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	ut.Token = token
+	return nil
+}
+
+func (utv *userTokenValidator) hmacToken(ut *userToken) error {
+	if ut.Token == "" {
+		return nil
+	}
+	ut.TokenHash = utv.hmac.Hash(ut.Token)
+	return nil
+}
+
+type userTokenValFn func(*userToken) error
+
+func runUserTokenValFns(ut *userToken, fns ...userTokenValFn) error {
+	for _, fn := range fns {
+		if err := fn(ut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type userTokenGorm struct {
+	db *gorm.DB
+}
+
+func (utg *userTokenGorm) ByToken(purpose, tokenHash string) (*userToken, error) {
+	var ut userToken
+	err := first(utg.db.Where("purpose = ? AND token_hash = ?", purpose, tokenHash), &ut)
+	if err != nil {
+		return nil, err
+	}
+	return &ut, nil
+}
+
+func (utg *userTokenGorm) Create(ut *userToken) error {
+	return utg.db.Create(ut).Error
+}
+
+func (utg *userTokenGorm) Delete(id uint) error {
+	ut := userToken{Model: gorm.Model{ID: id}}
+	db := utg.db.Delete(&ut)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (utg *userTokenGorm) DeleteByUserID(userID uint, purpose string) error {
+	return utg.db.Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&userToken{}).Error
+}
+
+func (utg *userTokenGorm) DeleteAllByUserID(userID uint) error {
+	return utg.db.Where("user_id = ?", userID).Delete(&userToken{}).Error
+}
+
+func (utg *userTokenGorm) Purge(before time.Time) error {
+	return utg.db.Unscoped().Where("expires_at < ? AND created_at < ?", time.Now(), before).
+		Delete(&userToken{}).Error
+}
+
+func (utg *userTokenGorm) CountSince(userID uint, purpose string, since time.Time) (int64, error) {
+	var n int64
+	err := utg.db.Unscoped().Model(&userToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&n).Error
+	return n, err
+}
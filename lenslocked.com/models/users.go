@@ -1,12 +1,15 @@
 package models
 
 import (
+	"errors"
+	"github.com/jackc/pgconn"
 	"github.com/username/project-name/hash"
-	"github.com/username/project-name/rand"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/username/project-name/password"
+	"github.com/username/project-name/totp"
 	"gorm.io/gorm"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,15 +21,47 @@ type User struct {
 	Email        string `gorm:"not null; uniqueIndex"`
 	Password     string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
-	Remember     string `gorm:"-"`
-	RememberHash string `gorm:"not null; uniqueIndex"`
+	Role         Role   `gorm:"not null;default:user"`
+	/*Username names the public profile page, it is optional and stored in lowercase*/
+	Username string `gorm:"index:idx_users_username,unique,where:username <> ''"`
+	Bio      string
+	/*Links holds up to maxLinks website addresses, one per line*/
+	Links          string
+	AvatarFilename string
+	/*EmailVerifiedAt is set once the user followed the link sent to Email*/
+	EmailVerifiedAt *time.Time
+
+	/*TOTPSecret is set as soon as the user starts enrolling an authenticator app,
+	the second factor is only enforced once TOTPEnabledAt is set*/
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	/*TOTPLastStep is the time step of the last accepted code so that it cannot be used twice*/
+	TOTPLastStep int64
+
+	/*Identities are only set when a user is created through an identity provider. Such a
+	user has no password until one is set with the reset password flow*/
+	Identities []Identity
+
+	/*DeletionRequestedAt is set while the account waits to be purged, see deletionGracePeriod*/
+	DeletionRequestedAt *time.Time `gorm:"index"`
+	/*SuspendedAt is set while an admin has locked the user out*/
+	SuspendedAt *time.Time
+}
+
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+/*TwoFactorEnabled reports whether the user has to provide a second factor to sign in*/
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
 }
 
 /*UserDB is used to interact with the database*/
 type UserDB interface {
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
+	ByUsername(username string) (*User, error)
 
 	Create(user *User) error
 	Update(user *User) error
@@ -35,22 +70,89 @@ type UserDB interface {
 
 /*UserService is a set of methods used to manipulate and work with the user model*/
 type UserService interface {
-	/*Authenticate will verify the provided email address and password are correct*/
-	Authenticate(email, password string) (*User, error)
+	/*Authenticate will verify the provided email address and password are correct. Repeated
+	failures from the same address or for the same account are answered with ErrTooManyAttempts*/
+	Authenticate(email, password, ip string) (*User, error)
+	/*Reauthenticate confirms the signed in user is still the one at the keyboard, with the
+	password or a code of the second factor. Failures count against the sign in throttle.
+	Users with neither confirm with their provider, see IdentityService.Reauthenticate*/
+	Reauthenticate(user *User, password, code, ip string) error
+	/*InitiateUnlock returns a token that lifts the lockout of the account with the provided email*/
+	InitiateUnlock(email string) (string, error)
+	CompleteUnlock(token string) error
+	/*InitiateReset returns a token to reset the password with, earlier tokens stop working.
+	ErrTooManyRequests is returned once too many were requested*/
 	InitiateReset(email string) (string, error)
-	CompleteReset(token, newPw string) (*User, error)
+	/*CompleteReset sets the new password and revokes every other way into the account that
+	whoever knew the old password may have: the sessions except the one with keepSessionID,
+	the API tokens and all emailed tokens. If the token belongs to a user but the password
+	can't be set, the user is returned along with the error, for the login history*/
+	CompleteReset(token, newPw string, keepSessionID uint) (*User, error)
+	/*PurgeExpiredTokens deletes the rows of tokens nobody can use any more*/
+	PurgeExpiredTokens() error
+
+	/*InitiateVerification returns a token proving the user owns the email address*/
+	InitiateVerification(user *User) (string, error)
+	CompleteVerification(token string) (*User, error)
+
+	/*InitiateEmailChange checks the password, see DisableTOTP, and the new address. It returns a
+	token confirming the new address and one that reverts the change, to be sent to the old address*/
+	InitiateEmailChange(user *User, password, code, newEmail string) (*EmailChange, error)
+	CompleteEmailChange(token string) (*User, error)
+	/*RevertEmailChange switches back to the address the revert token was sent to*/
+	RevertEmailChange(token string) (*User, error)
+
+	/*RequestDeletion schedules the account to be purged after a grace period. The
+	current password is required if the user has one*/
+	RequestDeletion(user *User, password string) error
+	CancelDeletion(user *User) error
+
+	/*Suspend locks the user out until Unsuspend is called. Sessions are left to the caller*/
+	Suspend(user *User) error
+	Unsuspend(user *User) error
+	/*ForcePasswordReset makes the current password stop working, revokes the sessions, API
+	tokens and emailed tokens of the user and returns a reset token*/
+	ForcePasswordReset(user *User) (string, error)
+
+	/*InitiateSignIn returns a token for a password-less sign-in link*/
+	InitiateSignIn(email string) (string, error)
+	CompleteSignIn(token string) (*User, error)
+
+	/*EnrollTOTP generates a new authenticator secret for the user. It is not enforced
+	until the user proves the app is set up with ConfirmTOTP*/
+	EnrollTOTP(user *User) (string, error)
+	/*ConfirmTOTP enables two-factor authentication and returns fresh recovery codes*/
+	ConfirmTOTP(user *User, code string) ([]string, error)
+	/*DisableTOTP turns two-factor authentication off. The current password is required, or
+	a code of the second factor if the user never set a password*/
+	DisableTOTP(user *User, password, code string) error
+	/*StartTwoFactor is called once the password of a user with two-factor authentication was
+	verified. The returned token identifies the half finished sign-in*/
+	StartTwoFactor(user *User) (string, error)
+	/*CompleteTwoFactor accepts either a TOTP code or a recovery code for the sign-in started with
+	the token. A wrong code is returned along with the user, for the login history*/
+	CompleteTwoFactor(token, code string) (*User, error)
 	UserDB
 }
 
-func NewUserService(db *gorm.DB, hmacKey string) UserService {
+func NewUserService(db *gorm.DB, keys *hash.Keyring, attempts AttemptStore, hasher password.Hasher,
+	policy PasswordPolicy, resetPolicy ResetPolicy) UserService {
 	ug := &userGorm{db}
 
-	hmac := hash.NewHMAC(hmacKey)
-	uv := newUserValidator(ug, hmac)
+	uv := newUserValidator(ug, keys, hasher, policy)
 
 	return &userService{
-		UserDB:    uv,
-		pwResetDB: newPwResetValidator(&pwResetGorm{db}, hmac),
+		UserDB:         uv,
+		validator:      uv,
+		pwResetDB:      newPwResetValidator(&pwResetGorm{db}, keys),
+		userTokenDB:    newUserTokenValidator(&userTokenGorm{db}, keys),
+		recoveryCodeDB: newRecoveryCodeValidator(&recoveryCodeGorm{db}, keys),
+		mfaChallengeDB: newMfaChallengeValidator(&mfaChallengeGorm{db}, keys),
+		sessionDB:      newSessionValidator(&sessionGorm{db}, keys),
+		apiTokenDB:     newAPITokenValidator(&apiTokenGorm{db}, keys),
+		throttle:       NewLoginThrottle(attempts),
+		hasher:         hasher,
+		resetPolicy:    resetPolicy.withDefaults(),
 	}
 }
 
@@ -58,27 +160,144 @@ var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
-	pwResetDB pwResetDB
-}
-
-func (us *userService) Authenticate(email, password string) (*User, error) {
-	if len(password) == 0 {
+	validator      *userValidator
+	pwResetDB      pwResetDB
+	userTokenDB    userTokenDB
+	recoveryCodeDB recoveryCodeDB
+	mfaChallengeDB mfaChallengeDB
+	sessionDB      SessionDB
+	apiTokenDB     APITokenDB
+	throttle       *LoginThrottle
+	hasher         password.Hasher
+	resetPolicy    ResetPolicy
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+/*Authenticate answers an unknown email the same way as a wrong password, so both
+count against the throttle and neither tells whether the account exists. A password is
+hashed either way so that the response time doesn't tell either*/
+func (us *userService) Authenticate(email, pw, ip string) (*User, error) {
+	if len(pw) == 0 {
 		return nil, ErrPasswordIsRequired
 	}
+	if err := us.throttle.Check(email, ip); err != nil {
+		return nil, err
+	}
 	user, err := us.ByEmail(email)
+	switch err {
+	case nil:
+		err = us.checkPassword(user, pw)
+	case ErrNotFound:
+		us.dummyCompare(pw)
+		err = ErrInvalidEmailOrPassword
+	}
+	if err == ErrInvalidEmailOrPassword {
+		locked, ferr := us.throttle.Fail(email, ip)
+		if ferr != nil {
+			return nil, ferr
+		}
+		if locked {
+			return nil, ErrTooManyAttempts
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err := us.throttle.Reset(email); err != nil {
+		return nil, err
+	}
+	/*only checked now, so the error doesn't tell anything to those without the password*/
+	if user.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	us.rehash(user, pw)
+	return user, nil
+}
+
+func (us *userService) Reauthenticate(user *User, pw, code, ip string) error {
+	if err := us.throttle.Check(user.Email, ip); err != nil {
+		return err
+	}
+	var err error
+	switch {
+	case pw != "":
+		err = us.checkPassword(user, pw)
+	case code != "":
+		err = us.verifySecondFactor(user, code)
+	default:
+		return ErrPasswordIsRequired
+	}
+	if err == ErrInvalidEmailOrPassword || err == ErrTOTPCodeInvalid {
+		locked, ferr := us.throttle.Fail(user.Email, ip)
+		if ferr != nil {
+			return ferr
+		}
+		if locked {
+			return ErrTooManyAttempts
+		}
+	}
+	return err
+}
+
+/*checkCredentials asks for the password before an account change. Users who never set a
+password give a code of the second factor instead. Users with neither have nothing to give,
+the pages are behind a recent sign in, which they confirm with their provider*/
+func (us *userService) checkCredentials(user *User, pw, code string) error {
+	switch {
+	case user.PasswordHash != "":
+		if len(pw) == 0 {
+			return ErrPasswordIsRequired
+		}
+		return us.checkPassword(user, pw)
+	case user.TwoFactorEnabled():
+		if len(code) == 0 {
+			return ErrTOTPCodeRequired
+		}
+		return us.verifySecondFactor(user, code)
+	}
+	return nil
+}
+
+func (us *userService) checkPassword(user *User, pw string) error {
+	if user.PasswordHash == "" {
+		us.dummyCompare(pw)
+		return ErrInvalidEmailOrPassword
+	}
+	err := password.Compare(user.PasswordHash, pw)
 	if err != nil {
 		switch err {
-		case bcrypt.ErrMismatchedHashAndPassword:
-			return nil, ErrInvalidEmailOrPassword
+		case password.ErrMismatch:
+			return ErrInvalidEmailOrPassword
 		default:
-			return nil, err
+			return err
 		}
 	}
-	return user, nil
+	return nil
+}
+
+/*dummyCompare takes as long as checking a real password with the configured hasher*/
+func (us *userService) dummyCompare(pw string) {
+	us.dummyOnce.Do(func() {
+		us.dummyHash, _ = us.hasher.Hash("not a real password")
+	})
+	password.Compare(us.dummyHash, pw)
+}
+
+/*rehash upgrades the stored hash once the configured algorithm or its parameters changed.
+It is only possible right after a successful sign in, when the plain password is known.
+A failure is not fatal, the old hash still works and the next sign in tries again*/
+func (us *userService) rehash(user *User, pw string) {
+	if !us.hasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	hashed, err := us.hasher.Hash(pw)
+	if err != nil {
+		return
+	}
+	user.PasswordHash = hashed
+	us.Update(user)
 }
 
 /*InitiateReset will start the reset password process by creating a reset
@@ -88,6 +307,17 @@ func (us *userService) InitiateReset(email string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	n, err := us.pwResetDB.CountSince(user.ID, time.Now().Add(-resetWindow))
+	if err != nil {
+		return "", err
+	}
+	if n >= int64(us.resetPolicy.Limit) {
+		return "", ErrTooManyRequests
+	}
+	/*only the link in the newest email works*/
+	if err := us.pwResetDB.DeleteByUserID(user.ID); err != nil {
+		return "", err
+	}
 	pwr := pwReset{
 		UserID: user.ID,
 	}
@@ -97,7 +327,7 @@ func (us *userService) InitiateReset(email string) (string, error) {
 	return pwr.Token, nil
 }
 
-func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+func (us *userService) CompleteReset(token, newPw string, keepSessionID uint) (*User, error) {
 	pwr, err := us.pwResetDB.ByToken(token)
 	if err != nil {
 		if err == ErrNotFound {
@@ -105,22 +335,175 @@ func (us *userService) CompleteReset(token, newPw string) (*User, error) {
 		}
 		return nil, err
 	}
-	if time.Now().Sub(pwr.CreatedAt) > (12 * time.Hour) {
-		return nil, ErrTokenInvalid
-	}
 	user, err := us.ByID(pwr.UserID)
 	if err != nil {
 		return nil, err
 	}
+	if time.Since(pwr.CreatedAt) > us.resetPolicy.ttl() {
+		return user, ErrTokenInvalid
+	}
+	if user.Suspended() {
+		return user, ErrAccountSuspended
+	}
 	user.Password = newPw
 	err = us.Update(user)
+	if err != nil {
+		return user, err
+	}
+	/*whoever knew the old password may have asked for links or tokens of their own*/
+	if err := us.revokeAccess(user.ID, keepSessionID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+/*revokeAccess deletes every way into the account but the password: the sessions except
+the one with keepSessionID, the API tokens, the reset tokens and all emailed tokens*/
+func (us *userService) revokeAccess(userID, keepSessionID uint) error {
+	if err := us.pwResetDB.DeleteByUserID(userID); err != nil {
+		return err
+	}
+	if err := us.userTokenDB.DeleteAllByUserID(userID); err != nil {
+		return err
+	}
+	if err := us.sessionDB.DeleteByUserID(userID, keepSessionID); err != nil {
+		return err
+	}
+	return us.apiTokenDB.DeleteByUserID(userID)
+}
+
+func (us *userService) PurgeExpiredTokens() error {
+	if err := us.pwResetDB.Purge(time.Now().Add(-us.resetPolicy.retention())); err != nil {
+		return err
+	}
+	return us.userTokenDB.Purge(time.Now().Add(-tokenRetention))
+}
+
+func (us *userService) EnrollTOTP(user *User) (string, error) {
+	if user.TwoFactorEnabled() {
+		return "", ErrTOTPAlreadyEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	user.TOTPSecret = secret
+	if err := us.Update(user); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (us *userService) ConfirmTOTP(user *User, code string) ([]string, error) {
+	if user.TwoFactorEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrTOTPCodeInvalid
+	}
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return us.newRecoveryCodes(user)
+}
+
+func (us *userService) DisableTOTP(user *User, password, code string) error {
+	if err := us.checkCredentials(user, password, code); err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	return us.recoveryCodeDB.DeleteByUserID(user.ID)
+}
+
+/*newRecoveryCodes replaces all recovery codes of the user. The codes are only
+returned here, we keep nothing but their hashes*/
+func (us *userService) newRecoveryCodes(user *User) ([]string, error) {
+	if err := us.recoveryCodeDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		rc := recoveryCode{UserID: user.ID}
+		if err := us.recoveryCodeDB.Create(&rc); err != nil {
+			return nil, err
+		}
+		codes = append(codes, rc.Code)
+	}
+	return codes, nil
+}
+
+func (us *userService) StartTwoFactor(user *User) (string, error) {
+	c := mfaChallenge{UserID: user.ID}
+	if err := us.mfaChallengeDB.Create(&c); err != nil {
+		return "", err
+	}
+	return c.Token, nil
+}
+
+func (us *userService) CompleteTwoFactor(token, code string) (*User, error) {
+	c, err := us.mfaChallengeDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if time.Since(c.CreatedAt) > mfaChallengeTTL || c.Attempts >= mfaMaxAttempts {
+		us.mfaChallengeDB.Delete(c.ID)
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(c.UserID)
 	if err != nil {
 		return nil, err
 	}
-	us.pwResetDB.Delete(pwr.ID)
+	if err := us.verifySecondFactor(user, code); err != nil {
+		c.Attempts++
+		us.mfaChallengeDB.Update(c)
+		return user, err
+	}
+	us.mfaChallengeDB.Delete(c.ID)
+	/*the user may have been suspended since the first step*/
+	if user.Suspended() {
+		return user, ErrAccountSuspended
+	}
 	return user, nil
 }
 
+/*verifySecondFactor accepts a TOTP code that has not been used yet or an unused recovery code*/
+func (us *userService) verifySecondFactor(user *User, code string) error {
+	if !user.TwoFactorEnabled() {
+		return ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if ok {
+		if step <= user.TOTPLastStep {
+			return ErrTOTPCodeInvalid
+		}
+		user.TOTPLastStep = step
+		return us.Update(user)
+	}
+	rc, err := us.recoveryCodeDB.ByCode(user.ID, code)
+	if err != nil {
+		if err == ErrNotFound {
+			return ErrTOTPCodeInvalid
+		}
+		return err
+	}
+	return us.recoveryCodeDB.Delete(rc.ID)
+}
+
 type userValFunc func(*User) error
 
 func runUserValFuncs(user *User, fns ...userValFunc) error {
@@ -134,17 +517,23 @@ func runUserValFuncs(user *User, fns ...userValFunc) error {
 
 var _ UserDB = &userValidator{}
 
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+var emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`)
+
+func newUserValidator(udb UserDB, hmac *hash.Keyring, hasher password.Hasher, policy PasswordPolicy) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
 		hmac:       hmac,
-		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
+		hasher:     hasher,
+		policy:     policy,
+		emailRegex: emailRegex,
 	}
 }
 
 type userValidator struct {
 	UserDB
-	hmac       hash.HMAC
+	hmac       *hash.Keyring
+	hasher     password.Hasher
+	policy     PasswordPolicy
 	emailRegex *regexp.Regexp
 }
 
@@ -158,31 +547,34 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
-func (uv *userValidator) ByRemember(token string) (*User, error) {
+func (uv *userValidator) ByUsername(username string) (*User, error) {
 	user := User{
-		Remember: token,
+		Username: username,
 	}
-	if err := runUserValFuncs(&user, uv.hmacRemember); err != nil {
+	if err := runUserValFuncs(&user, uv.normalizeUsername); err != nil {
 		return nil, err
 	}
-	return uv.UserDB.ByRemember(user.RememberHash)
+	return uv.UserDB.ByUsername(user.Username)
 }
 
 func (uv *userValidator) Create(user *User) error {
 	if err := runUserValFuncs(
 		user,
 		uv.passwordRequired,
-		uv.passwordMinLength,
-		uv.bcryptPassword,
+		uv.passwordPolicy,
+		uv.hashPassword,
 		uv.passwordHashRequired,
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
 		uv.emailIsAvail,
+		uv.normalizeUsername,
+		uv.usernameFormat,
+		uv.usernameIsAvail,
+		uv.bioLength,
+		uv.normalizeLinks,
+		uv.defaultRole,
+		uv.validRole,
 	); err != nil {
 		return err
 	}
@@ -192,22 +584,35 @@ func (uv *userValidator) Create(user *User) error {
 func (uv *userValidator) Update(user *User) error {
 	if err := runUserValFuncs(
 		user,
-		uv.passwordMinLength,
-		uv.bcryptPassword,
-		uv.passwordHashRequired,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
+		uv.passwordPolicy,
+		uv.hashPassword,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
 		uv.emailIsAvail,
+		uv.normalizeUsername,
+		uv.usernameFormat,
+		uv.usernameIsAvail,
+		uv.bioLength,
+		uv.normalizeLinks,
+		uv.validRole,
 	); err != nil {
 		return err
 	}
 	return uv.UserDB.Update(user)
 }
 
+/*checkNewEmail validates an address a user wants to switch to*/
+func (uv *userValidator) checkNewEmail(user *User) error {
+	return runUserValFuncs(
+		user,
+		uv.normalizeEmail,
+		uv.requireEmail,
+		uv.emailFormat,
+		uv.emailIsAvail,
+	)
+}
+
 func (uv *userValidator) Delete(id uint) error {
 	var user User
 	user.ID = id
@@ -218,67 +623,24 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
-func (uv *userValidator) bcryptPassword(user *User) error {
+func (uv *userValidator) hashPassword(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
-	pwBytes := []byte(user.Password)
-	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	hashed, err := uv.hasher.Hash(user.Password)
 	if err != nil {
 		return err
 	}
-	user.PasswordHash = string(hashedBytes)
+	user.PasswordHash = hashed
 	user.Password = ""
 
 	return nil
 }
 
-func (uv *userValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
 type userGorm struct {
 	db *gorm.DB
 }
 
-func (uv *userValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != "" {
-		return nil
-	}
-
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.Remember = token
-	return nil
-}
-
-func (uv *userValidator) rememberMinBytes(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
-	}
-	if n < 32 {
-		return ErrRememberIsShort
-	}
-	return nil
-}
-
-func (uv *userValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return ErrRememberHashIsRequired
-	}
-	return nil
-}
-
 func (uv *userValidator) idGreaterThan(n uint) userValFunc {
 	return userValFunc(func(user *User) error {
 		if user.ID <= 0 {
@@ -325,17 +687,31 @@ func (uv *userValidator) emailIsAvail(user *User) error {
 	return nil
 }
 
-func (uv *userValidator) passwordMinLength(user *User) error {
-	if user.Password == "" {
-		return nil
+func (uv *userValidator) defaultRole(user *User) error {
+	if user.Role == "" {
+		user.Role = RoleUser
 	}
-	if len(user.Password) < 8 {
-		return ErrPasswordIsShort
+	return nil
+}
+
+func (uv *userValidator) validRole(user *User) error {
+	if !user.Role.Valid() {
+		return ErrRoleInvalid
 	}
 	return nil
 }
 
+func (uv *userValidator) passwordPolicy(user *User) error {
+	if user.Password == "" {
+		return nil
+	}
+	return uv.policy.Check(user.Password, user)
+}
+
 func (uv *userValidator) passwordRequired(user *User) error {
+	if len(user.Identities) > 0 {
+		return nil
+	}
 	if len(user.Password) == 0 {
 		return ErrPasswordIsRequired
 	}
@@ -343,6 +719,9 @@ func (uv *userValidator) passwordRequired(user *User) error {
 }
 
 func (uv *userValidator) passwordHashRequired(user *User) error {
+	if len(user.Identities) > 0 {
+		return nil
+	}
 	if len(user.PasswordHash) == 0 {
 		return ErrPasswordIsRequired
 	}
@@ -356,27 +735,19 @@ func (ug *userGorm) Delete(id uint) error {
 	return ug.db.Delete(&user).Error
 }
 
-/*ByRemember looks up the user with a token and returns that user.*/
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	if rememberHash == "" {
-		return nil, ErrInvalidToken
-	}
-
+func (ug *userGorm) ByEmail(email string) (*User, error) {
 	var user User
-	db := ug.db.Where("remember_hash = ?", rememberHash)
+	db := ug.db.Where("email = ?", email)
 	err := first(db, &user)
-
 	if err != nil {
-		return nil, ErrInvalidToken
+		return nil, err
 	}
-
 	return &user, nil
 }
 
-func (ug *userGorm) ByEmail(email string) (*User, error) {
+func (ug *userGorm) ByUsername(username string) (*User, error) {
 	var user User
-	db := ug.db.Where("email = ?", email)
-	err := first(db, &user)
+	err := first(ug.db.Where("username = ?", username), &user)
 	if err != nil {
 		return nil, err
 	}
@@ -395,12 +766,32 @@ func (ug *userGorm) ByID(id uint) (*User, error) {
 	}
 	return &user, nil
 }
+
+/*Create and Update rely on the unique index for emails that were registered after
+emailIsAvail had a look*/
 func (ug *userGorm) Create(user *User) error {
-	return ug.db.Create(user).Error
+	return uniqueViolation(ug.db.Create(user).Error)
 }
 
 func (ug *userGorm) Update(user *User) error {
-	return ug.db.Save(user).Error
+	return uniqueViolation(ug.db.Save(user).Error)
+}
+
+/*uniqueViolation turns a race lost on one of the unique columns into the error the validator
+would have returned*/
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	switch {
+	case strings.Contains(pgErr.ConstraintName, "email"):
+		return ErrEmailIsRegistered
+	case strings.Contains(pgErr.ConstraintName, "username"):
+		return ErrUsernameTaken
+	default:
+		return err
+	}
 }
 
 func first(db *gorm.DB, dst interface{}) error {
@@ -2,11 +2,15 @@ package models
 
 import (
 	"fmt"
+	"github.com/username/project-name/hash"
+	"github.com/username/project-name/password"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 	"testing"
 	"time"
 )
 
-func testingUserService() (*UserService, error) {
+func testingUserService() (UserService, error) {
 	const (
 		host     = "localhost"
 		port     = 5432
@@ -19,14 +23,20 @@ func testingUserService() (*UserService, error) {
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
-	us, err := NewUserService(dsn)
+	keys, err := hash.NewKeyring(hash.Key{ID: "test", Secret: "secret-key"})
+	if err != nil {
+		return nil, err
+	}
+	services, err := NewServices(WithGorm(postgres.Open(dsn)), WithUser(keys))
 	if err != nil {
 		return nil, err
 	}
 
-	us.ResetDB()
+	if err := services.ResetDB(); err != nil {
+		return nil, err
+	}
 
-	return us, nil
+	return services.User, nil
 }
 
 func TestCreateUser(t *testing.T) {
@@ -56,3 +66,55 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("Expected UpdatedAt to be recent. Received %s", user.UpdatedAt)
 	}
 }
+
+/*countingHasher counts the hashes it makes*/
+type countingHasher struct {
+	password.Hasher
+	hashed int
+}
+
+func (h *countingHasher) Hash(pw string) (string, error) {
+	h.hashed++
+	return h.Hasher.Hash(pw)
+}
+
+/*fakeEmailDB knows a single account*/
+type fakeEmailDB struct {
+	UserDB
+	user *User
+}
+
+func (db *fakeEmailDB) ByEmail(email string) (*User, error) {
+	if email != db.user.Email {
+		return nil, ErrNotFound
+	}
+	return db.user, nil
+}
+
+func TestAuthenticateDoesNotRevealAccounts(t *testing.T) {
+	hasher := &countingHasher{Hasher: password.Bcrypt{Cost: 4}}
+	hashed, err := hasher.Hasher.Hash("the right password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Model: gorm.Model{ID: 1}, Email: "taken@example.com", PasswordHash: hashed}
+	us := &userService{
+		UserDB:   &fakeEmailDB{user: user},
+		throttle: NewLoginThrottle(NewMemoryAttemptStore()),
+		hasher:   hasher,
+	}
+
+	if _, err := us.Authenticate(user.Email, "a wrong password", "10.0.0.1"); err != ErrInvalidEmailOrPassword {
+		t.Errorf("wrong password: Expected %v, Received %v", ErrInvalidEmailOrPassword, err)
+	}
+	if hasher.hashed != 0 || us.dummyHash != "" {
+		t.Errorf("Expected the stored hash to be compared, Received %d hashes", hasher.hashed)
+	}
+	if _, err := us.Authenticate("nobody@example.com", "a wrong password", "10.0.0.2"); err != ErrInvalidEmailOrPassword {
+		t.Errorf("unknown email: Expected %v, Received %v", ErrInvalidEmailOrPassword, err)
+	}
+	/*the unknown address is compared against a real hash, which takes as long as a wrong password*/
+	if hasher.hashed != 1 || password.Compare(us.dummyHash, "a wrong password") != password.ErrMismatch {
+		t.Errorf("Expected a password to be compared for the unknown email, Received %d hashes", hasher.hashed)
+	}
+}
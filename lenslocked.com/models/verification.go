@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+/*verificationTTL is how long an email verification link stays valid*/
+const verificationTTL = 48 * time.Hour
+
+/*VerificationPolicy decides what a user may do before the email address has been verified*/
+type VerificationPolicy struct {
+	/*BlockGalleries stops unverified users from creating galleries*/
+	BlockGalleries bool `json:"block_galleries"`
+	/*BlockSharing hides the galleries of unverified users from everybody else*/
+	BlockSharing bool `json:"block_sharing"`
+}
+
+func (p VerificationPolicy) CanCreateGallery(user *User) bool {
+	return !p.BlockGalleries || user.EmailVerified()
+}
+
+func (p VerificationPolicy) CanShare(owner *User) bool {
+	return !p.BlockSharing || owner.EmailVerified()
+}
+
+/*InitiateVerification issues a new verification token for the current email address of the user.
+Tokens issued before are invalidated*/
+func (us *userService) InitiateVerification(user *User) (string, error) {
+	if user.EmailVerified() {
+		return "", ErrEmailAlreadyVerified
+	}
+	if err := us.userTokenDB.DeleteByUserID(user.ID, tokenVerifyEmail); err != nil {
+		return "", err
+	}
+	ut := userToken{
+		UserID:    user.ID,
+		Purpose:   tokenVerifyEmail,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(verificationTTL),
+	}
+	if err := us.userTokenDB.Create(&ut); err != nil {
+		return "", err
+	}
+	return ut.Token, nil
+}
+
+/*CompleteVerification marks the email address the token was sent to as verified*/
+func (us *userService) CompleteVerification(token string) (*User, error) {
+	ut, err := us.userTokenDB.ByToken(tokenVerifyEmail, token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if ut.Expired() {
+		us.userTokenDB.Delete(ut.ID)
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(ut.UserID)
+	if err != nil {
+		return nil, err
+	}
+	/*the address might have changed since the link was sent*/
+	if user.Email != ut.Email {
+		us.userTokenDB.Delete(ut.ID)
+		return nil, ErrTokenInvalid
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	us.userTokenDB.Delete(ut.ID)
+	return user, nil
+}
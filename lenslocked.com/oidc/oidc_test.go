@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/username/project-name/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	clientID    = "lenslocked"
+	redirectURL = "http://localhost:3000/oauth/mock/callback"
+)
+
+/*authorize runs the browser part of the flow and returns the code and state the issuer redirected back with*/
+func authorize(t *testing.T, p *Provider, state, nonce, verifier string) (string, string) {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return follow(t, authURL)
+}
+
+/*follow sends the browser to the issuer and returns the code and state it redirected back with*/
+func follow(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), redirectURL) {
+		t.Fatalf("Expected a redirect to %s, Received %s", redirectURL, loc)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func newTestProvider(iss *oidctest.Issuer) *Provider {
+	return NewProvider(Config{
+		Name:        "mock",
+		Issuer:      iss.URL,
+		ClientID:    clientID,
+		RedirectURL: redirectURL,
+	})
+}
+
+func TestFlow(t *testing.T) {
+	iss := oidctest.NewIssuer(clientID)
+	defer iss.Close()
+	p := newTestProvider(iss)
+	ctx := context.Background()
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := authorize(t, p, "the-state", "the-nonce", verifier)
+	if state != "the-state" {
+		t.Errorf("Expected state to round trip, Received %q", state)
+	}
+	token, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(ctx, token.IDToken, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1234567890" || claims.Email != "jane@example.com" || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if !claims.AuthenticatedAt().IsZero() {
+		t.Errorf("Expected no auth_time without max_age, Received %v", claims.AuthenticatedAt())
+	}
+
+	if _, err := p.Exchange(ctx, code, verifier); err == nil {
+		t.Error("Expected a code to be usable only once")
+	}
+}
+
+func TestReauth(t *testing.T) {
+	iss := oidctest.NewIssuer(clientID)
+	defer iss.Close()
+	p := newTestProvider(iss)
+	ctx := context.Background()
+
+	verifier, _ := NewVerifier()
+	authURL, err := p.ReauthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("prompt") != "login" || q.Get("max_age") != "0" {
+		t.Errorf("Expected the provider to be asked for a fresh login, Received %s", authURL)
+	}
+	code, _ := follow(t, authURL)
+	token, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(ctx, token.IDToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(claims.AuthenticatedAt()) > time.Minute {
+		t.Errorf("Expected a recent auth_time, Received %v", claims.AuthenticatedAt())
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	iss := oidctest.NewIssuer(clientID)
+	defer iss.Close()
+	p := newTestProvider(iss)
+
+	verifier, _ := NewVerifier()
+	other, _ := NewVerifier()
+	code, _ := authorize(t, p, "state", "nonce", verifier)
+	if _, err := p.Exchange(context.Background(), code, other); err == nil {
+		t.Error("Expected the exchange to fail with another code verifier")
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	iss := oidctest.NewIssuer(clientID)
+	defer iss.Close()
+	p := newTestProvider(iss)
+	ctx := context.Background()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   iss.URL,
+			"sub":   "42",
+			"aud":   []string{"someone-else", clientID},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": "nonce",
+		}
+	}
+	if _, err := p.Verify(ctx, iss.Sign(valid()), "nonce"); err != nil {
+		t.Fatalf("Expected a valid token to pass, Received %v", err)
+	}
+
+	cases := map[string]func(map[string]interface{}){
+		"wrong issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"wrong audience": func(c map[string]interface{}) { c["aud"] = "someone-else" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future iat":     func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"wrong nonce":    func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		"no subject":     func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, breakClaims := range cases {
+		claims := valid()
+		breakClaims(claims)
+		if _, err := p.Verify(ctx, iss.Sign(claims), "nonce"); err == nil {
+			t.Errorf("%s: Expected the token to be rejected", name)
+		}
+	}
+
+	signed := iss.Sign(valid())
+	parts := strings.Split(signed, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	if _, err := p.Verify(ctx, none+"."+parts[1]+".", "nonce"); err == nil {
+		t.Error("Expected an unsigned token to be rejected")
+	}
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"` + iss.URL + `","sub":"1"}`))
+	if _, err := p.Verify(ctx, parts[0]+"."+tampered+"."+parts[2], "nonce"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for a tampered payload, Received %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+/*ErrBloomFormat is returned when a serialized bloom filter cannot be read*/
+var ErrBloomFormat = errors.New("password: invalid bloom filter")
+
+/*Bloom is a bloom filter over lower-cased passwords. It answers "definitely not in
+the set" or "probably in the set" while taking a fraction of the size of the list*/
+type Bloom struct {
+	k    uint32
+	bits []uint64
+}
+
+/*NewBloom sizes a filter for n entries with the wanted false positive rate*/
+func NewBloom(n int, fpRate float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{
+		k:    uint32(k),
+		bits: make([]uint64, (uint64(m)+63)/64),
+	}
+}
+
+func (b *Bloom) Add(s string) {
+	h1, h2 := bloomHashes(s)
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < uint64(b.k); i++ {
+		idx := (h1 + i*h2) % m
+		b.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+/*Test reports whether s is probably in the set*/
+func (b *Bloom) Test(s string) bool {
+	if len(b.bits) == 0 {
+		return false
+	}
+	h1, h2 := bloomHashes(s)
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < uint64(b.k); i++ {
+		idx := (h1 + i*h2) % m
+		if b.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*MarshalBinary encodes the filter as the number of hashes followed by the bit words, all little endian*/
+func (b *Bloom) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 4+8*len(b.bits))
+	binary.LittleEndian.PutUint32(data, b.k)
+	for i, w := range b.bits {
+		binary.LittleEndian.PutUint64(data[4+8*i:], w)
+	}
+	return data, nil
+}
+
+func (b *Bloom) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 || (len(data)-4)%8 != 0 {
+		return ErrBloomFormat
+	}
+	b.k = binary.LittleEndian.Uint32(data)
+	if b.k == 0 {
+		return ErrBloomFormat
+	}
+	b.bits = make([]uint64, (len(data)-4)/8)
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[4+8*i:])
+	}
+	return nil
+}
+
+/*bloomHashes derives the two hashes for double hashing from a single SHA-256*/
+func bloomHashes(s string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(strings.ToLower(s)))
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	return h1, h2
+}
@@ -0,0 +1,31 @@
+package password
+
+import (
+	_ "embed"
+	"sync"
+)
+
+//go:generate go run gen_common.go -in common.txt -out common.bloom
+
+/*commonBloom is generated from common.txt. Only the filter is compiled in, so a much
+larger breached password list can be used without growing the binary by its size*/
+//go:embed common.bloom
+var commonBloom []byte
+
+var (
+	commonOnce   sync.Once
+	commonFilter *Bloom
+)
+
+/*IsCommon reports whether the password is on the bundled list of common and breached
+passwords. The check ignores case. About one in a thousand other passwords is
+rejected as well, which is the price for keeping the list small*/
+func IsCommon(pw string) bool {
+	commonOnce.Do(func() {
+		commonFilter = &Bloom{}
+		if err := commonFilter.UnmarshalBinary(commonBloom); err != nil {
+			panic(err)
+		}
+	})
+	return commonFilter.Test(pw)
+}
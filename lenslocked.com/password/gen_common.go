@@ -0,0 +1,54 @@
+//go:build ignore
+// +build ignore
+
+/*gen_common builds the bloom filter of common passwords from a list with one password per line*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"github.com/username/project-name/password"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	in := flag.String("in", "common.txt", "list of passwords, one per line")
+	out := flag.String("out", "common.bloom", "where to write the filter")
+	fpRate := flag.Float64("fp", 0.001, "false positive rate")
+	flag.Parse()
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	var pws []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pw := strings.TrimSpace(scanner.Text())
+		if pw == "" || strings.HasPrefix(pw, "#") {
+			continue
+		}
+		pws = append(pws, pw)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	b := password.NewBloom(len(pws), *fpRate)
+	for _, pw := range pws {
+		b.Add(pw)
+	}
+	data, err := b.MarshalBinary()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d passwords into %d bytes", len(pws), len(data))
+}
@@ -0,0 +1,196 @@
+/*Package password hashes passwords for storage. Hashes are encoded together with the
+algorithm and its parameters, so a stored hash can always be verified even after the
+configured algorithm or its cost changed*/
+package password
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/username/project-name/rand"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var (
+	/*ErrMismatch is returned when the password doesn't match the hash*/
+	ErrMismatch = errors.New("password: password does not match")
+	/*ErrUnknownFormat is returned for hashes that none of the hashers produced*/
+	ErrUnknownFormat = errors.New("password: unknown hash format")
+)
+
+/*Hasher hashes passwords with one algorithm and one set of parameters*/
+type Hasher interface {
+	/*Hash returns the encoded hash of the password*/
+	Hash(password string) (string, error)
+	/*NeedsRehash reports whether encoded was made with another algorithm or other parameters
+	than the ones of the hasher*/
+	NeedsRehash(encoded string) bool
+}
+
+/*Compare checks the password against an encoded hash made by any of the supported
+algorithms. It returns ErrMismatch if the password is wrong*/
+func Compare(encoded, password string) error {
+	switch {
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		return compareArgon2id(encoded, password)
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrMismatch
+		}
+		return err
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+/*Config selects the algorithm used for new hashes. Zero values fall back to the defaults*/
+type Config struct {
+	/*Algorithm is either "argon2id" or "bcrypt"*/
+	Algorithm string `json:"algorithm"`
+	/*Time, Memory in KiB and Threads are the argon2id parameters*/
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	/*Cost is the bcrypt cost*/
+	Cost int `json:"cost"`
+}
+
+/*Hasher returns the hasher described by the config*/
+func (c Config) Hasher() (Hasher, error) {
+	switch c.Algorithm {
+	case "", "argon2id":
+		h := DefaultArgon2id
+		if c.Time > 0 {
+			h.Time = c.Time
+		}
+		if c.Memory > 0 {
+			h.Memory = c.Memory
+		}
+		if c.Threads > 0 {
+			h.Threads = c.Threads
+		}
+		return h, nil
+	case "bcrypt":
+		h := Bcrypt{Cost: bcrypt.DefaultCost}
+		if c.Cost > 0 {
+			h.Cost = c.Cost
+		}
+		if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("password: bcrypt cost %d is out of range", h.Cost)
+		}
+		return h, nil
+	default:
+		return nil, fmt.Errorf("password: unknown algorithm %q", c.Algorithm)
+	}
+}
+
+const argon2idPrefix = "$argon2id$"
+
+/*DefaultArgon2id follows the parameters recommended in RFC 9106*/
+var DefaultArgon2id = Argon2id{
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+/*Argon2id produces hashes in the PHC string format,
+$argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>*/
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt, err := rand.Bytes(a.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.Time != a.Time || p.Memory != a.Memory || p.Threads != a.Threads ||
+		len(key) != int(a.KeyLen) || len(salt) != a.SaltLen
+}
+
+func compareArgon2id(encoded, password string) error {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var p Argon2id
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	p.KeyLen = uint32(len(key))
+	p.SaltLen = len(salt)
+	return p, salt, key, nil
+}
+
+/*Bcrypt keeps bcrypt's own encoding, $2a$<cost>$<salt and hash>, which is what
+all existing password hashes use*/
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	if !isBcrypt(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
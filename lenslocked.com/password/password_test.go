@@ -0,0 +1,115 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+/*small parameters keep the tests fast*/
+var testArgon2id = Argon2id{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+func TestCompare(t *testing.T) {
+	hashers := map[string]Hasher{
+		"argon2id": testArgon2id,
+		"bcrypt":   Bcrypt{Cost: 4},
+	}
+	for name, h := range hashers {
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Compare(encoded, "correct horse"); err != nil {
+			t.Errorf("%s: Expected the password to match, Received %v", name, err)
+		}
+		if err := Compare(encoded, "wrong horse"); err != ErrMismatch {
+			t.Errorf("%s: Expected ErrMismatch, Received %v", name, err)
+		}
+		if h.NeedsRehash(encoded) {
+			t.Errorf("%s: Expected a fresh hash to be current", name)
+		}
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	encoded, err := testArgon2id.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Unexpected encoding %s", encoded)
+	}
+	if err := Compare("$argon2id$v=19$m=1024,t=1,p=1$broken", "secret"); err != ErrUnknownFormat {
+		t.Errorf("Expected ErrUnknownFormat, Received %v", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	old, err := Bcrypt{Cost: 4}.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(Bcrypt{Cost: 5}).NeedsRehash(old) {
+		t.Error("Expected a bcrypt hash with another cost to need a rehash")
+	}
+	if !testArgon2id.NeedsRehash(old) {
+		t.Error("Expected a bcrypt hash to need a rehash when argon2id is configured")
+	}
+	encoded, err := testArgon2id.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stronger := testArgon2id
+	stronger.Time = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("Expected an argon2id hash with other parameters to need a rehash")
+	}
+	if !(Bcrypt{Cost: 4}).NeedsRehash(encoded) {
+		t.Error("Expected an argon2id hash to need a rehash when bcrypt is configured")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	h, err := Config{}.Hasher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != Hasher(DefaultArgon2id) {
+		t.Errorf("Expected the default argon2id hasher, Received %+v", h)
+	}
+	if _, err := (Config{Algorithm: "bcrypt", Cost: 99}).Hasher(); err == nil {
+		t.Error("Expected an out of range bcrypt cost to be rejected")
+	}
+	if _, err := (Config{Algorithm: "md5"}).Hasher(); err == nil {
+		t.Error("Expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestIsCommon(t *testing.T) {
+	for _, pw := range []string{"password", "Password123", "qwerty", "letmein"} {
+		if !IsCommon(pw) {
+			t.Errorf("Expected %q to be common", pw)
+		}
+	}
+	if IsCommon("tr0mb0ne-lantern-sextant") {
+		t.Error("Expected a long random passphrase not to be common")
+	}
+}
+
+func TestBloomRoundTrip(t *testing.T) {
+	b := NewBloom(100, 0.01)
+	b.Add("hunter2")
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var other Bloom
+	if err := other.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !other.Test("HUNTER2") {
+		t.Error("Expected the filter to contain hunter2 regardless of case")
+	}
+	if err := other.UnmarshalBinary(data[:5]); err != ErrBloomFormat {
+		t.Errorf("Expected ErrBloomFormat, Received %v", err)
+	}
+}
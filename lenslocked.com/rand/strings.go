@@ -5,7 +5,11 @@ import (
 	"encoding/base64"
 )
 
-const RememberTokenBytes = 32
+const (
+	RememberTokenBytes = 32
+	/*NonceBytes is enough randomness for nonces to never repeat*/
+	NonceBytes = 16
+)
 
 func Bytes(n int) ([]byte, error) {
 	b := make([]byte, n)
@@ -35,3 +39,12 @@ func String(nBytes int) (string, error) {
 func RememberToken() (string, error) {
 	return String(RememberTokenBytes)
 }
+
+/*Nonce returns a random URL safe value to tell apart otherwise equal signed tokens*/
+func Nonce() (string, error) {
+	b, err := Bytes(NonceBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,34 @@
+package rand
+
+import "testing"
+
+func TestRememberToken(t *testing.T) {
+	token, err := RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := NBytes(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != RememberTokenBytes {
+		t.Errorf("Expected %d bytes, Received %d", RememberTokenBytes, n)
+	}
+}
+
+func TestNonce(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		nonce, err := Nonce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nonce) != 22 {
+			t.Errorf("Expected 22 characters for %d bytes, Received %q", NonceBytes, nonce)
+		}
+		if seen[nonce] {
+			t.Fatalf("Expected unique nonces, Received %q twice", nonce)
+		}
+		seen[nonce] = true
+	}
+}
@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+/*The SHA1 test vectors from RFC 6238 Appendix B, truncated to six digits*/
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, c := range cases {
+		got, err := Code(secret, Step(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.code {
+			t.Errorf("Expected %s at %d, Received %s", c.code, c.unix, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := Code(secret, Step(now.Add(-Period*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, ok := Validate(secret, code, now)
+	if !ok {
+		t.Fatal("Expected the previous code to be accepted")
+	}
+	if step != Step(now)-1 {
+		t.Errorf("Expected step %d, Received %d", Step(now)-1, step)
+	}
+
+	old, _ := Code(secret, Step(now)-Skew-1)
+	if _, ok := Validate(secret, old, now); ok {
+		t.Error("Expected a code outside of the skew window to be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("Expected a short code to be rejected")
+	}
+}
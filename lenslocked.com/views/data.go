@@ -1,6 +1,8 @@
 package views
 
 import (
+	"context"
+	"github.com/username/project-name/cookies"
 	"github.com/username/project-name/models"
 	"net/http"
 	"time"
@@ -25,7 +27,9 @@ type Alert struct {
 type Data struct {
 	Alert *Alert
 	User  *models.User
-	Yield interface{}
+	/*Impersonator is the admin signed in as User, the layout shows a banner while it is set*/
+	Impersonator *models.User
+	Yield        interface{}
 }
 
 func (d *Data) SetAlert(err error) {
@@ -52,53 +56,73 @@ type PublicError interface {
 	Public() string
 }
 
-func persistAlert(w http.ResponseWriter, alert Alert) {
-	expiresAt := time.Now().Add(5 * time.Minute)
-	lvl := http.Cookie{
-		Name:     "alert_level",
-		Value:    alert.Level,
-		Expires:  expiresAt,
-		HttpOnly: true,
-	}
-	msg := http.Cookie{
-		Name:     "alert_message",
-		Value:    alert.Message,
-		Expires:  expiresAt,
-		HttpOnly: true,
-	}
-	http.SetCookie(w, &lvl)
-	http.SetCookie(w, &msg)
+type privateKey string
+
+const alertsKey privateKey = "alerts"
+
+/*Alerts keeps alerts in cookies until the page they were meant for is shown. The cookies are
+as secure as the session cookie*/
+type Alerts struct {
+	level   *cookies.Cookie
+	message *cookies.Cookie
 }
-func clearAlert(w http.ResponseWriter) {
-	lvl := http.Cookie{
-		Name:     "alert_level",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
+
+/*NewAlerts sets the path of the cookies to "/", so that the alert is cleared on whichever
+page shows it*/
+func NewAlerts(rc *cookies.Remember) *Alerts {
+	return &Alerts{
+		level:   rc.Cookie("alert_level", "/"),
+		message: rc.Cookie("alert_message", "/"),
 	}
-	msg := http.Cookie{
-		Name:     "alert_message",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
+}
+
+/*Apply hands the alerts to RedirectAlert and Render for every request*/
+func (a *Alerts) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), alertsKey, a)))
+	})
+}
+
+/*plainAlerts are used for requests that didn't go through Alerts.Apply*/
+var plainAlerts = func() *Alerts {
+	rc, _ := cookies.Config{}.Remember(false)
+	return NewAlerts(rc)
+}()
+
+func alertsFor(r *http.Request) *Alerts {
+	if a, ok := r.Context().Value(alertsKey).(*Alerts); ok {
+		return a
 	}
-	http.SetCookie(w, &lvl)
-	http.SetCookie(w, &msg)
+	return plainAlerts
+}
+
+func persistAlert(w http.ResponseWriter, r *http.Request, alert Alert) {
+	a := alertsFor(r)
+	expiresAt := time.Now().Add(5 * time.Minute)
+	a.level.Set(w, alert.Level, expiresAt)
+	a.message.Set(w, alert.Message, expiresAt)
+}
+
+func clearAlert(w http.ResponseWriter, r *http.Request) {
+	a := alertsFor(r)
+	a.level.Clear(w)
+	a.message.Clear(w)
 }
 
 func getAlert(r *http.Request) *Alert {
-	lvl, err := r.Cookie("alert_level")
+	a := alertsFor(r)
+	lvl, err := a.level.Value(r)
 	if err != nil {
 		return nil
 	}
-	msg, err := r.Cookie("alert_message")
+	msg, err := a.message.Value(r)
 	if err != nil {
 		return nil
 	}
 
 	alert := Alert{
-		Level:   lvl.Value,
-		Message: msg.Value,
+		Level:   lvl,
+		Message: msg,
 	}
 
 	return &alert
@@ -107,6 +131,6 @@ func getAlert(r *http.Request) *Alert {
 /*RedirectAlert accepts all the normal params for an http.Redirect and performs a redirect, but only
 after persisting the provided alert in a cookie so that it can be displayed when the new page is loaded*/
 func RedirectAlert(w http.ResponseWriter, r *http.Request, urlStr string, code int, alert Alert) {
-	persistAlert(w, alert)
+	persistAlert(w, r, alert)
 	http.Redirect(w, r, urlStr, code)
 }
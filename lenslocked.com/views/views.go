@@ -67,10 +67,11 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 
 	if alert := getAlert(r); alert != nil {
 		vd.Alert = alert
-		clearAlert(w)
+		clearAlert(w, r)
 	}
 
 	vd.User = context.User(r.Context())
+	vd.Impersonator = context.Impersonator(r.Context())
 	var buf bytes.Buffer
 	csrfField := csrf.TemplateField(r)
 	tmpl := v.Template.Funcs(template.FuncMap{